@@ -0,0 +1,235 @@
+package baselith
+
+import (
+	"fmt"
+	"strings"
+)
+
+// changeTypes lists the declarative change types accepted inside a kind="change" changelog.
+var changeTypes = map[string]bool{
+	"createTable":          true,
+	"addColumn":            true,
+	"dropColumn":           true,
+	"renameColumn":         true,
+	"createIndex":          true,
+	"addForeignKey":        true,
+	"addNotNullConstraint": true,
+}
+
+// buildChanges renders the declarative changes of m into up statements and
+// their automatically generated inverse. down is nil when at least one change
+// cannot be inverted; noDown then names the offending change type.
+func buildChanges(d dialect, m xmlChangelog) (up, down []string, noDown string, err error) {
+	if len(m.Changes) == 0 {
+		return nil, nil, "", fmt.Errorf("%s: kind=change requires at least one change element", m.ID)
+	}
+
+	var inverses [][]string
+	for _, c := range m.Changes {
+		name := c.XMLName.Local
+		if !changeTypes[name] {
+			return nil, nil, "", fmt.Errorf("%s: unknown change type <%s>", m.ID, name)
+		}
+
+		u, inv, err := buildChange(d, c)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("%s: <%s>: %w", m.ID, name, err)
+		}
+		up = append(up, u...)
+		if inv == nil && noDown == "" {
+			noDown = name
+		}
+		inverses = append(inverses, inv)
+	}
+
+	if noDown != "" {
+		return up, nil, noDown, nil
+	}
+	// undo the changes in reverse order
+	for i := len(inverses) - 1; i >= 0; i-- {
+		down = append(down, inverses[i]...)
+	}
+	return up, down, "", nil
+}
+
+// buildChange renders a single change; inv is nil when it has no automatic inverse.
+func buildChange(d dialect, c xmlChange) (up, inv []string, err error) {
+	switch c.XMLName.Local {
+	case "createTable":
+		if c.TableName == "" || len(c.Columns) == 0 {
+			return nil, nil, fmt.Errorf("tableName and at least one <column> are required")
+		}
+		var (
+			defs []string
+			pks  []string
+		)
+		for _, col := range c.Columns {
+			if col.PrimaryKey {
+				pks = append(pks, col.Name)
+			}
+		}
+		for _, col := range c.Columns {
+			def, err := d.columnDef(col, len(pks) == 1)
+			if err != nil {
+				return nil, nil, err
+			}
+			defs = append(defs, def)
+		}
+		if len(pks) > 1 {
+			defs = append(defs, "PRIMARY KEY ("+d.quoteList(strings.Join(pks, ","))+")")
+		}
+		up = []string{fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", d.table(c.TableName), strings.Join(defs, ",\n\t"))}
+		inv = []string{fmt.Sprintf("DROP TABLE %s", d.table(c.TableName))}
+
+	case "addColumn":
+		if c.TableName == "" || len(c.Columns) == 0 {
+			return nil, nil, fmt.Errorf("tableName and at least one <column> are required")
+		}
+		for _, col := range c.Columns {
+			def, err := d.columnDef(col, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			up = append(up, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", d.table(c.TableName), def))
+		}
+		for i := len(c.Columns) - 1; i >= 0; i-- {
+			inv = append(inv, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", d.table(c.TableName), d.quote(c.Columns[i].Name)))
+		}
+
+	case "dropColumn":
+		// the nested <column> elements describe the dropped columns so that they can be restored
+		if c.TableName == "" || (c.ColumnName == "" && len(c.Columns) == 0) {
+			return nil, nil, fmt.Errorf("tableName and columnName (or <column>) are required")
+		}
+		cols := c.Columns
+		if c.ColumnName != "" {
+			cols = []xmlColumn{{Name: c.ColumnName, Type: c.ColumnDataType}}
+			for _, col := range c.Columns {
+				if col.Name == c.ColumnName {
+					cols[0] = col
+				}
+			}
+		}
+		restorable := true
+		for _, col := range cols {
+			up = append(up, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", d.table(c.TableName), d.quote(col.Name)))
+			if col.Type == "" {
+				restorable = false
+			}
+		}
+		if restorable {
+			for i := len(cols) - 1; i >= 0; i-- {
+				def, err := d.columnDef(cols[i], true)
+				if err != nil {
+					return nil, nil, err
+				}
+				inv = append(inv, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", d.table(c.TableName), def))
+			}
+		}
+
+	case "renameColumn":
+		if c.TableName == "" || c.OldColumnName == "" || c.NewColumnName == "" {
+			return nil, nil, fmt.Errorf("tableName, oldColumnName and newColumnName are required")
+		}
+		rename := "ALTER TABLE %s RENAME COLUMN %s TO %s"
+		up = []string{fmt.Sprintf(rename, d.table(c.TableName), d.quote(c.OldColumnName), d.quote(c.NewColumnName))}
+		inv = []string{fmt.Sprintf(rename, d.table(c.TableName), d.quote(c.NewColumnName), d.quote(c.OldColumnName))}
+
+	case "createIndex":
+		if c.TableName == "" || c.IndexName == "" || len(c.Columns) == 0 {
+			return nil, nil, fmt.Errorf("tableName, indexName and at least one <column> are required")
+		}
+		names := make([]string, len(c.Columns))
+		for i, col := range c.Columns {
+			names[i] = col.Name
+		}
+		unique := ""
+		if c.Unique {
+			unique = "UNIQUE "
+		}
+		up = []string{fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+			unique, d.quote(c.IndexName), d.table(c.TableName), d.quoteList(strings.Join(names, ",")))}
+		if d.isPostgres() {
+			schema, _ := d.splitTable(c.TableName)
+			inv = []string{fmt.Sprintf("DROP INDEX %s.%s", d.quote(schema), d.quote(c.IndexName))}
+		} else {
+			inv = []string{fmt.Sprintf("DROP INDEX %s ON %s", d.quote(c.IndexName), d.table(c.TableName))}
+		}
+
+	case "addForeignKey":
+		if c.BaseTableName == "" || c.BaseColumnNames == "" || c.ReferencedTableName == "" ||
+			c.ReferencedColumnNames == "" || c.ConstraintName == "" {
+			return nil, nil, fmt.Errorf("baseTableName, baseColumnNames, referencedTableName, referencedColumnNames and constraintName are required")
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			d.table(c.BaseTableName), d.quote(c.ConstraintName), d.quoteList(c.BaseColumnNames),
+			d.table(c.ReferencedTableName), d.quoteList(c.ReferencedColumnNames))
+		if c.OnDelete != "" {
+			stmt += " ON DELETE " + strings.ToUpper(c.OnDelete)
+		}
+		if c.OnUpdate != "" {
+			stmt += " ON UPDATE " + strings.ToUpper(c.OnUpdate)
+		}
+		up = []string{stmt}
+		if d.isPostgres() {
+			inv = []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", d.table(c.BaseTableName), d.quote(c.ConstraintName))}
+		} else {
+			inv = []string{fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", d.table(c.BaseTableName), d.quote(c.ConstraintName))}
+		}
+
+	case "addNotNullConstraint":
+		if c.TableName == "" || c.ColumnName == "" {
+			return nil, nil, fmt.Errorf("tableName and columnName are required")
+		}
+		if !d.isPostgres() && c.ColumnDataType == "" {
+			return nil, nil, fmt.Errorf("columnDataType is required for %s", d.driver)
+		}
+		if c.DefaultNullValue != nil {
+			up = append(up, fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NULL",
+				d.table(c.TableName), d.quote(c.ColumnName), d.literal(*c.DefaultNullValue), d.quote(c.ColumnName)))
+		}
+		if d.isPostgres() {
+			up = append(up, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", d.table(c.TableName), d.quote(c.ColumnName)))
+			inv = []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", d.table(c.TableName), d.quote(c.ColumnName))}
+		} else {
+			modify := "ALTER TABLE %s MODIFY %s %s %s"
+			typ := d.columnType(c.ColumnDataType)
+			up = append(up, fmt.Sprintf(modify, d.table(c.TableName), d.quote(c.ColumnName), typ, "NOT NULL"))
+			inv = []string{fmt.Sprintf(modify, d.table(c.TableName), d.quote(c.ColumnName), typ, "NULL")}
+		}
+	}
+	return up, inv, nil
+}
+
+// columnDef renders a column definition. inlinePK is false when the primary key
+// is declared as a table constraint.
+func (d dialect) columnDef(col xmlColumn, inlinePK bool) (string, error) {
+	if col.Name == "" || col.Type == "" {
+		return "", fmt.Errorf("column name and type are required")
+	}
+
+	parts := []string{d.quote(col.Name), d.columnType(col.Type)}
+	if col.AutoIncrement {
+		if d.isPostgres() {
+			parts = append(parts, "GENERATED BY DEFAULT AS IDENTITY")
+		} else {
+			parts = append(parts, "AUTO_INCREMENT")
+		}
+	}
+	if col.Nullable != nil && !*col.Nullable {
+		parts = append(parts, "NOT NULL")
+	}
+	switch {
+	case col.DefaultValueComputed != "":
+		parts = append(parts, "DEFAULT "+col.DefaultValueComputed)
+	case col.DefaultValue != nil:
+		parts = append(parts, "DEFAULT "+d.literal(*col.DefaultValue))
+	}
+	if col.PrimaryKey && inlinePK {
+		parts = append(parts, "PRIMARY KEY")
+	}
+	if col.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	return strings.Join(parts, " "), nil
+}
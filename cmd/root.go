@@ -21,9 +21,146 @@ func init() {
 		Short: "Print the version number of Baselith",
 		Long:  `All software has versions. This is Baselith's`,
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Fprintln(cmd.OutOrStdout(), "Baselith v1.0.0")
+			fmt.Fprintln(cmd.OutOrStdout(), "Baselith v"+baselith.Version)
 		},
 	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Validate the changelog without connecting to the database",
+		Long:  `Parses migrations.xml and every file it includes and reports all problems at once.`,
+		Run:   baselith.RunValidate,
+	})
+	lintCmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check changeset SQL for dangerous migration patterns",
+		Long:  `Inspects the SQL of every changeset for the configured driver against a rule set with severities.`,
+		Run:   baselith.RunLint,
+	}
+	baselith.ReadLintFlags(lintCmd)
+	rootCmd.AddCommand(lintCmd)
+
+	testRollbackCmd := &cobra.Command{
+		Use:   "test-rollback",
+		Short: "Check that every changeset rolls back cleanly",
+		Long: `Applies each changeset in a disposable schema, rolls it back, checks that the schema
+matches the snapshot taken before applying it, and re-applies it.`,
+		Run: baselith.RunTestRollback,
+	}
+	baselith.ReadTestRollbackFlags(testRollbackCmd)
+	rootCmd.AddCommand(testRollbackCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "snapshot",
+		Short: "Dump the current schema to schema.sql and schema.json",
+		Long: `Introspects tables, columns, indexes, constraints, sequences, views and functions of the
+configured schema and writes a deterministic, sorted dump next to the changelog.`,
+		Run: baselith.RunSnapshot,
+	})
+
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the schema with another database or a snapshot",
+		Long: `Compares the schema objects of the configured database with another database (--target-config)
+or a schema.json snapshot (--target-snapshot). Exits with 2 when the schemas differ.`,
+		Run: baselith.RunDiff,
+	}
+	baselith.ReadDiffFlags(diffCmd)
+	rootCmd.AddCommand(diffCmd)
+
+	diffChangelogCmd := &cobra.Command{
+		Use:   "diff-changelog",
+		Short: "Generate changesets from a schema diff",
+		Long: `Compares the configured (reference) database with --target-config or --target-snapshot and appends
+changesets to migrations.xml, with up and down SQL under changeset/, that transform the target into the reference.`,
+		Run: baselith.RunDiffChangelog,
+	}
+	baselith.ReadDiffChangelogFlags(diffChangelogCmd)
+	rootCmd.AddCommand(diffChangelogCmd)
+
+	generateChangelogCmd := &cobra.Command{
+		Use:   "generate-changelog",
+		Short: "Generate an initial changelog from an existing database",
+		Long: `Reverse-engineers the sequences, tables, indexes, foreign keys, views and functions of an existing
+schema into ordered changesets, written as migrations.xml plus SQL files in --folder.`,
+		Run: baselith.RunGenerateChangelog,
+	}
+	baselith.ReadGenerateChangelogFlags(generateChangelogCmd)
+	rootCmd.AddCommand(generateChangelogCmd)
+
+	newCmd := &cobra.Command{
+		Use:   "new <name>",
+		Short: "Scaffold a new changeset",
+		Long: `Creates the next changeset: picks the next numeric id, creates its files under changeset/ from
+the templates of its kind and appends a <changeLog> to migrations.xml, keeping the rest of the file as is.`,
+		Args: cobra.ExactArgs(1),
+		Run:  baselith.RunNew,
+	}
+	baselith.ReadNewFlags(newCmd)
+	rootCmd.AddCommand(newCmd)
+
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the changelog format schema",
+	}
+	schemaCmd.AddCommand(&cobra.Command{
+		Use:   "xsd",
+		Short: "Print the XSD of the changelog format",
+		Long:  `Prints the versioned XSD describing migrations.xml, for editor validation and autocompletion.`,
+		Run:   baselith.RunSchemaXSD,
+	})
+	rootCmd.AddCommand(schemaCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "ping",
+		Short: "Check that the database is reachable",
+		Long: `Connects to the database (waiting up to --wait-timeout for it to start), then prints the server version
+and whether the migration table exists. Exits non-zero when the database cannot be reached.`,
+		Run: baselith.RunPing,
+	})
+
+	repairCmd := &cobra.Command{
+		Use:   "repair [id]",
+		Short: "Resolve a changeset left dirty by a failed non-transactional execution",
+		Long: `Without an id, lists the dirty changesets. With an id, records how the failed execution was resolved:
+--mark=completed once its remaining statements were applied by hand, --mark=reverted once its effects were undone.`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  baselith.RunRepair,
+	}
+	baselith.ReadRepairFlags(repairCmd)
+	rootCmd.AddCommand(repairCmd)
+
+	lockCmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Inspect or release the migration lock",
+	}
+	lockCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show who holds the migration lock",
+		Long:  `Prints the holder of the migration lock of --lock-mode, and whether a table lock is stale.`,
+		Run:   baselith.RunLockStatus,
+	})
+	lockReleaseCmd := &cobra.Command{
+		Use:   "release",
+		Short: "Release a migration lock left behind by another process",
+		Long: `Releases a stale table lock. With --force, releases any lock: the table row is deleted and the
+session holding an advisory lock is terminated.`,
+		Run: baselith.RunLockRelease,
+	}
+	baselith.ReadLockReleaseFlags(lockReleaseCmd)
+	lockCmd.AddCommand(lockReleaseCmd)
+	rootCmd.AddCommand(lockCmd)
+
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Inspect the environments of the config file",
+	}
+	envCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the environments of the config file",
+		Long:  `Prints every environment of the --config file with the shared settings merged in, passwords redacted.`,
+		Run:   baselith.RunEnvList,
+	})
+	rootCmd.AddCommand(envCmd)
 	baselith.ReadFlags(rootCmd)
 }
 
@@ -0,0 +1,326 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hinha/baselith/persistence"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the environment variable of every config key.
+const envPrefix = "BASELITH_"
+
+// configSetting ties a key of the YAML config file and its BASELITH_<KEY>
+// environment variable to the flag holding the value.
+type configSetting struct {
+	key  string
+	flag string
+	// target is set when the executing command has no such flag
+	target *string
+}
+
+// configSettings lists the settings resolved by applyConfig, in the order of the YAML file.
+var configSettings = []configSetting{
+	{key: "driver", flag: "driver"},
+	{key: "host", flag: "host"},
+	{key: "port", flag: "port"},
+	{key: "dbname", flag: "dbname"},
+	{key: "user", flag: "user"},
+	{key: "password", flag: "password"},
+	{key: "password_file", flag: "password-file"},
+	{key: "password_env", flag: "password-env"},
+	{key: "password_command", flag: "password-command"},
+	{key: "schema", flag: "schema", target: &Schema},
+	{key: "sslmode", flag: "sslmode"},
+	{key: "options", flag: "db-option"},
+	{key: "max_idle_conns", flag: "max-idle-conns"},
+	{key: "max_open_conns", flag: "max-open-conns"},
+	{key: "conn_max_lifetime", flag: "conn-max-lifetime"},
+	{key: "folder", flag: "folder"},
+	{key: "contexts", flag: "contexts"},
+	{key: "labels", flag: "label-filter"},
+	{key: "lock_mode", flag: "lock-mode"},
+	{key: "lock_timeout", flag: "lock-timeout"},
+	{key: "statement_timeout", flag: "statement-timeout"},
+	{key: "statement_lock_timeout", flag: "statement-lock-timeout"},
+	{key: "wait_timeout", flag: "wait-timeout"},
+}
+
+// commandFlags are the flags of the executing command, set before it runs.
+var commandFlags *pflag.FlagSet
+
+// lookupCommandFlag returns the flag name of the executing command, or nil.
+func lookupCommandFlag(name string) *pflag.Flag {
+	if commandFlags == nil {
+		return nil
+	}
+	return commandFlags.Lookup(name)
+}
+
+// passwordKeys are the alternative ways of giving the password. They are
+// resolved together, from the first place giving any of them, so that e.g.
+// --password-file overrides a password in the config file.
+var passwordKeys = map[string]bool{
+	"password": true, "password_file": true, "password_env": true, "password_command": true,
+}
+
+// passwordSource returns the index of the source the password settings are
+// taken from, -1 for the command line, or len(sources) when none sets one.
+// A place setting several of them is an error.
+func passwordSource(sources []configSource) (int, error) {
+	var flags []string
+	for _, s := range configSettings {
+		if f := lookupCommandFlag(s.flag); passwordKeys[s.key] && f != nil && f.Changed {
+			flags = append(flags, "--"+s.flag)
+		}
+	}
+	if len(flags) > 1 {
+		return 0, fmt.Errorf("only one of %s may be given", strings.Join(flags, ", "))
+	}
+	if len(flags) == 1 {
+		return -1, nil
+	}
+
+	for i, src := range sources {
+		var keys []string
+		for _, s := range configSettings {
+			if _, ok := src.keys[s.key]; passwordKeys[s.key] && ok {
+				keys = append(keys, src.name(s.key))
+			}
+		}
+		if len(keys) > 1 {
+			return 0, fmt.Errorf("only one of %s may be set", strings.Join(keys, ", "))
+		}
+		if len(keys) == 1 {
+			return i, nil
+		}
+	}
+	return len(sources), nil
+}
+
+// configSource is a place settings are read from, in order of precedence.
+type configSource struct {
+	name func(key string) string // for error messages
+	keys map[string]string
+	url  string // where the connection URL came from, "" when not a URL
+	// options is set when the options are a map rather than the k=v,k=v text
+	// of --db-option, whose values cannot hold a comma or an equals sign
+	options map[string]string
+}
+
+// urlTargetKeys are the settings naming the database a connection URL connects to.
+var urlTargetKeys = []string{"driver", "host", "port", "dbname"}
+
+// dropShadowedURLs treats every connection URL as one unit: a URL is ignored
+// entirely when a place of the same or higher precedence names the database
+// separately, so that the password of e.g. an ambient DATABASE_URL is never
+// sent to a host the URL did not supply. --url together with such a flag is an error.
+func dropShadowedURLs(sources []configSource) ([]configSource, error) {
+	var flags []string
+	for _, key := range urlTargetKeys {
+		if f := lookupCommandFlag(key); f != nil && f.Changed {
+			flags = append(flags, "--"+key)
+		}
+	}
+
+	var out []configSource
+	for _, src := range sources {
+		if src.url == "" {
+			out = append(out, src)
+			continue
+		}
+		if len(flags) > 0 {
+			if src.url == "--url" {
+				return nil, fmt.Errorf("--url cannot be combined with %s", strings.Join(flags, ", "))
+			}
+			log.Printf("Ignoring %s: the database is given by %s", src.url, strings.Join(flags, ", "))
+			continue
+		}
+		if by := targetKeysOf(out); by != "" {
+			log.Printf("Ignoring %s: the database is given by %s", src.url, by)
+			continue
+		}
+		out = append(out, src)
+	}
+	return out, nil
+}
+
+// targetKeysOf returns where the first of sources naming the database does so, or "".
+func targetKeysOf(sources []configSource) string {
+	for _, src := range sources {
+		var names []string
+		for _, key := range urlTargetKeys {
+			if _, ok := src.keys[key]; ok {
+				names = append(names, src.name(key))
+			}
+		}
+		if len(names) > 0 && src.url != "" {
+			return src.url
+		}
+		if len(names) > 0 {
+			return strings.Join(names, ", ")
+		}
+	}
+	return ""
+}
+
+// envKeys returns the settings given as BASELITH_<KEY> environment variables.
+func envKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, s := range configSettings {
+		if value, ok := os.LookupEnv(envName(s.key)); ok {
+			keys[s.key] = value
+		}
+	}
+	return keys
+}
+
+// urlSource returns the settings held by a connection URL.
+func urlSource(source, raw string) (configSource, error) {
+	c, err := persistence.ParseURL(raw)
+	if err != nil {
+		return configSource{}, fmt.Errorf("%s: %w", source, err)
+	}
+	keys := map[string]string{
+		"driver": c.Driver,
+		"host":   c.Host,
+		"port":   strconv.Itoa(c.Port),
+		"dbname": c.Database,
+	}
+	// keep a user or password given elsewhere when the URL has none
+	if c.Username != "" {
+		keys["user"] = c.Username
+	}
+	if c.Password != "" {
+		keys["password"] = c.Password
+	}
+	if c.SSLMode != "" {
+		keys["sslmode"] = c.SSLMode
+	}
+	if len(c.Params) > 0 {
+		keys["options"] = optionsText(c.Params)
+	}
+	return configSource{name: func(string) string { return source }, keys: keys, url: source, options: c.Params}, nil
+}
+
+// optionsText formats options as k=v,k=v, sorted by key, for messages.
+func optionsText(options map[string]string) string {
+	pairs := make([]string, 0, len(options))
+	for k, v := range options {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// envName returns the environment variable of a config key.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// applyConfig resolves every setting once, in order of precedence: a flag given
+// on the command line, its environment variable, the YAML config file, and the
+// flag default. A connection URL (--url, BASELITH_URL or DATABASE_URL, url in
+// the YAML) supplies its settings just below those given separately at the same level.
+func applyConfig() error {
+	if f := lookupCommandFlag("env"); f == nil || !f.Changed {
+		if v, ok := os.LookupEnv(envName("env")); ok {
+			Env = v
+		}
+	}
+	if Env != "" && (!ConfigYaml || ConfigPath == "") {
+		return fmt.Errorf("environment %q: --env needs the YAML config (--yaml --config)", Env)
+	}
+
+	var (
+		yamlKeys    map[string]string
+		yamlOptions map[string]string
+	)
+	if ConfigYaml && ConfigPath != "" {
+		cfg, keys, err := readConfigYAMLKeys(ConfigPath, Env)
+		if err != nil {
+			return fmt.Errorf("failed to read YAML config: %w", err)
+		}
+		yamlKeys, yamlOptions = keys, cfg.Options
+		configParams = cfg.Params
+	}
+
+	// a connection URL stands for the settings it holds, at the precedence of
+	// where it was given; it is dropped when the database is named separately
+	// at the same or a higher precedence, else settings such as the user given
+	// separately take precedence over its own
+	sources := []configSource{{name: func(key string) string { return envName(key) }, keys: envKeys()}}
+	if f := lookupCommandFlag("url"); f != nil && f.Changed {
+		src, err := urlSource("--url", URL)
+		if err != nil {
+			return err
+		}
+		sources = append([]configSource{src}, sources...)
+	}
+	for _, name := range []string{envName("url"), "DATABASE_URL"} {
+		if raw, ok := os.LookupEnv(name); ok && raw != "" {
+			src, err := urlSource(name, raw)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, src)
+			break
+		}
+	}
+	yamlName := func(key string) string {
+		if Env != "" {
+			return ConfigPath + ": " + Env + ": " + key
+		}
+		return ConfigPath + ": " + key
+	}
+	sources = append(sources, configSource{name: yamlName, keys: yamlKeys, options: yamlOptions})
+	if raw := yamlKeys["url"]; raw != "" {
+		src, err := urlSource(yamlName("url"), raw)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, src)
+	}
+	sources, err := dropShadowedURLs(sources)
+	if err != nil {
+		return err
+	}
+
+	passwordFrom, err := passwordSource(sources)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range configSettings {
+		f := lookupCommandFlag(s.flag)
+		if f != nil && f.Changed {
+			continue
+		}
+		for i, src := range sources {
+			if passwordKeys[s.key] && i != passwordFrom {
+				continue
+			}
+			value, ok := src.keys[s.key]
+			if !ok {
+				continue
+			}
+			switch {
+			case s.key == "options" && src.options != nil:
+				DBOptions = src.options
+			case f != nil:
+				if err := f.Value.Set(value); err != nil {
+					return fmt.Errorf("%s: invalid value %q: %w", src.name(s.key), value, err)
+				}
+			case s.target != nil:
+				*s.target = value
+			}
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package baselith
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DDL rendering of snapshot objects, shared by the schema dump and the
+// changelog generators. Definitions are used as introspected.
+
+func (d dialect) createSequenceSQL(q sequenceSnapshot) string {
+	sql := fmt.Sprintf("CREATE SEQUENCE %s AS %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d",
+		d.table(q.Name), q.Type, q.Start, q.Increment, q.Min, q.Max)
+	if q.Cycle {
+		sql += " CYCLE"
+	}
+	return sql + ";"
+}
+
+func (d dialect) alterSequenceSQL(q sequenceSnapshot) string {
+	cycle := " NO CYCLE"
+	if q.Cycle {
+		cycle = " CYCLE"
+	}
+	return fmt.Sprintf("ALTER SEQUENCE %s AS %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d%s;",
+		d.table(q.Name), q.Type, q.Start, q.Increment, q.Min, q.Max, cycle)
+}
+
+func (d dialect) dropSequenceSQL(name string) string {
+	return fmt.Sprintf("DROP SEQUENCE %s;", d.table(name))
+}
+
+// createTableSQL renders a table with its columns and every constraint except
+// foreign keys, which are added separately so that tables can be created in any order.
+func (d dialect) createTableSQL(t tableSnapshot) string {
+	var defs []string
+	for _, c := range t.Columns {
+		defs = append(defs, d.snapshotColumnDef(c))
+	}
+	for _, c := range t.Constraints {
+		if c.Type != "FOREIGN KEY" {
+			defs = append(defs, fmt.Sprintf("CONSTRAINT %s %s", d.quote(c.Name), c.Definition))
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n    %s\n);", d.table(t.Name), strings.Join(defs, ",\n    "))
+}
+
+func (d dialect) dropTableSQL(name string) string {
+	return fmt.Sprintf("DROP TABLE %s;", d.table(name))
+}
+
+// snapshotColumnDef renders an introspected column of a CREATE TABLE.
+func (d dialect) snapshotColumnDef(c columnSnapshot) string {
+	def := d.quote(c.Name) + " " + c.Type
+	switch c.Identity {
+	case "":
+	case "AUTO_INCREMENT":
+		def += " AUTO_INCREMENT"
+	default:
+		def += " GENERATED " + c.Identity + " AS IDENTITY"
+	}
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	if c.Default != nil {
+		def += " DEFAULT " + d.snapshotDefault(*c.Default)
+	}
+	return def
+}
+
+// snapshotDefault renders a column default. Postgres returns expressions, MySQL
+// returns string defaults unquoted.
+func (d dialect) snapshotDefault(v string) string {
+	if d.isPostgres() {
+		return v
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	upper := strings.ToUpper(v)
+	if upper == "NULL" || strings.HasPrefix(upper, "CURRENT_TIMESTAMP") || strings.HasPrefix(v, "(") {
+		return v
+	}
+	return d.literal(v)
+}
+
+func (d dialect) addColumnSQL(table string, c columnSnapshot) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", d.table(table), d.snapshotColumnDef(c))
+}
+
+func (d dialect) dropColumnSQL(table, column string) string {
+	return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", d.table(table), d.quote(column))
+}
+
+// alterColumnSQL renders the statements changing column from into column to.
+// MySQL redefines the whole column.
+func (d dialect) alterColumnSQL(table string, from, to columnSnapshot) []string {
+	if !d.isPostgres() {
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s;", d.table(table), d.snapshotColumnDef(to))}
+	}
+
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", d.table(table), d.quote(to.Name))
+	var stmts []string
+	if from.Type != to.Type {
+		stmts = append(stmts, alter+"TYPE "+to.Type+";")
+	}
+	if from.Nullable != to.Nullable {
+		if to.Nullable {
+			stmts = append(stmts, alter+"DROP NOT NULL;")
+		} else {
+			stmts = append(stmts, alter+"SET NOT NULL;")
+		}
+	}
+	if defaultString(from.Default) != defaultString(to.Default) {
+		if to.Default == nil {
+			stmts = append(stmts, alter+"DROP DEFAULT;")
+		} else {
+			stmts = append(stmts, alter+"SET DEFAULT "+*to.Default+";")
+		}
+	}
+	if from.Identity != to.Identity {
+		switch {
+		case to.Identity == "":
+			stmts = append(stmts, alter+"DROP IDENTITY;")
+		case from.Identity == "":
+			stmts = append(stmts, alter+"ADD GENERATED "+to.Identity+" AS IDENTITY;")
+		default:
+			stmts = append(stmts, alter+"SET GENERATED "+to.Identity+";")
+		}
+	}
+	return stmts
+}
+
+func (d dialect) createIndexSQL(ix indexSnapshot) string {
+	return strings.TrimRight(strings.TrimSpace(ix.Definition), ";") + ";"
+}
+
+func (d dialect) dropIndexSQL(table, name string) string {
+	if d.isPostgres() {
+		return fmt.Sprintf("DROP INDEX %s;", d.table(name))
+	}
+	return fmt.Sprintf("DROP INDEX %s ON %s;", d.quote(name), d.table(table))
+}
+
+func (d dialect) addConstraintSQL(table string, c constraintSnapshot) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;", d.table(table), d.quote(c.Name), c.Definition)
+}
+
+func (d dialect) dropConstraintSQL(table string, c constraintSnapshot) string {
+	if d.isPostgres() {
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", d.table(table), d.quote(c.Name))
+	}
+	switch c.Type {
+	case "PRIMARY KEY":
+		return fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY;", d.table(table))
+	case "FOREIGN KEY":
+		return fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s;", d.table(table), d.quote(c.Name))
+	case "UNIQUE":
+		return fmt.Sprintf("ALTER TABLE %s DROP INDEX %s;", d.table(table), d.quote(c.Name))
+	default:
+		return fmt.Sprintf("ALTER TABLE %s DROP CHECK %s;", d.table(table), d.quote(c.Name))
+	}
+}
+
+func (d dialect) createViewSQL(v viewSnapshot) string {
+	create := "CREATE VIEW"
+	if v.Materialized {
+		create = "CREATE MATERIALIZED VIEW"
+	}
+	return fmt.Sprintf("%s %s AS\n%s;", create, d.table(v.Name), strings.TrimRight(strings.TrimSpace(v.Definition), ";"))
+}
+
+func (d dialect) dropViewSQL(v viewSnapshot) string {
+	if v.Materialized {
+		return fmt.Sprintf("DROP MATERIALIZED VIEW %s;", d.table(v.Name))
+	}
+	return fmt.Sprintf("DROP VIEW %s;", d.table(v.Name))
+}
+
+func (d dialect) createFunctionSQL(f functionSnapshot) string {
+	return strings.TrimRight(strings.TrimSpace(f.Definition), ";") + ";"
+}
+
+// dropFunctionSQL drops a function. Postgres names carry the argument types.
+func (d dialect) dropFunctionSQL(f functionSnapshot) string {
+	name, args := f.Name, ""
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name, args = name[:i], name[i:]
+	}
+	return fmt.Sprintf("DROP %s %s%s;", f.Kind, d.table(name), args)
+}
+
+// schemaQualifier matches "schema.", quoted or not, in an introspected definition.
+func schemaQualifier(schema string) *regexp.Regexp {
+	q := regexp.QuoteMeta(schema)
+	return regexp.MustCompile("(\"" + q + "\"|`" + q + "`|\\b" + q + ")\\.")
+}
+
+// requalify returns a copy of s whose definitions and defaults refer to the
+// schema as target (e.g. "${schema}") instead of s.Schema.
+func (s *schemaSnapshot) requalify(target string) *schemaSnapshot {
+	re := schemaQualifier(s.Schema)
+	fix := func(v string) string { return re.ReplaceAllLiteralString(v, target+".") }
+
+	out := *s
+	out.Schema = target
+	out.Tables = make([]tableSnapshot, len(s.Tables))
+	for i, t := range s.Tables {
+		nt := tableSnapshot{Name: t.Name}
+		for _, c := range t.Columns {
+			if c.Default != nil {
+				v := fix(*c.Default)
+				c.Default = &v
+			}
+			nt.Columns = append(nt.Columns, c)
+		}
+		for _, ix := range t.Indexes {
+			ix.Definition = fix(ix.Definition)
+			nt.Indexes = append(nt.Indexes, ix)
+		}
+		for _, c := range t.Constraints {
+			c.Definition = fix(c.Definition)
+			nt.Constraints = append(nt.Constraints, c)
+		}
+		out.Tables[i] = nt
+	}
+	out.Views = make([]viewSnapshot, len(s.Views))
+	for i, v := range s.Views {
+		v.Definition = fix(v.Definition)
+		out.Views[i] = v
+	}
+	out.Functions = make([]functionSnapshot, len(s.Functions))
+	for i, f := range s.Functions {
+		f.Definition = fix(f.Definition)
+		out.Functions[i] = f
+	}
+	return &out
+}
+
+// foreignKeyOrder orders tables so that referenced tables come first. Foreign
+// keys that still point at a later table (reference cycles) are returned as
+// deferred, to be added once every table exists.
+func foreignKeyOrder(tables []tableSnapshot) (ordered []tableSnapshot, deferred map[string][]constraintSnapshot) {
+	byName := make(map[string]tableSnapshot, len(tables))
+	for _, t := range tables {
+		byName[t.Name] = t
+	}
+	deferred = make(map[string][]constraintSnapshot)
+	state := make(map[string]int) // 0 new, 1 visiting, 2 done
+	var visit func(t tableSnapshot)
+	visit = func(t tableSnapshot) {
+		state[t.Name] = 1
+		for _, c := range t.Constraints {
+			if c.Type != "FOREIGN KEY" || c.RefTable == t.Name {
+				continue
+			}
+			ref, ok := byName[c.RefTable]
+			if !ok {
+				continue
+			}
+			switch state[ref.Name] {
+			case 0:
+				visit(ref)
+			case 1:
+				deferred[t.Name] = append(deferred[t.Name], c)
+			}
+		}
+		state[t.Name] = 2
+		ordered = append(ordered, t)
+	}
+	for _, t := range tables {
+		if state[t.Name] == 0 {
+			visit(t)
+		}
+	}
+	return ordered, deferred
+}
@@ -0,0 +1,120 @@
+package baselith
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dialect renders identifiers, literals and column types for a database driver.
+type dialect struct {
+	driver string // "postgres" | "mysql"
+	schema string // default schema for unqualified postgres tables
+}
+
+func newDialect(driver, schema string) (dialect, error) {
+	switch driver {
+	case "postgres", "postgresql":
+		return dialect{driver: "postgres", schema: schema}, nil
+	case "mysql":
+		return dialect{driver: "mysql", schema: schema}, nil
+	default:
+		return dialect{}, fmt.Errorf("unsupported driver: %s", driver)
+	}
+}
+
+func (d dialect) isPostgres() bool {
+	return d.driver == "postgres"
+}
+
+// quote quotes a single identifier.
+func (d dialect) quote(name string) string {
+	if d.isPostgres() {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteList quotes a comma separated list of identifiers.
+func (d dialect) quoteList(names string) string {
+	parts := splitList(names)
+	for i, p := range parts {
+		parts[i] = d.quote(p)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitTable splits a table name following the xmlTable convention ("public.m_roles")
+// into its schema and name. Unqualified postgres tables use the dialect schema.
+func (d dialect) splitTable(name string) (schema, table string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	if d.isPostgres() {
+		return d.schema, name
+	}
+	return "", name
+}
+
+// table quotes a possibly schema qualified table name.
+func (d dialect) table(name string) string {
+	schema, table := d.splitTable(name)
+	if schema == "" {
+		return d.quote(table)
+	}
+	return d.quote(schema) + "." + d.quote(table)
+}
+
+// literal renders a string literal.
+func (d dialect) literal(v string) string {
+	v = strings.ReplaceAll(v, "'", "''")
+	if !d.isPostgres() {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+	}
+	return "'" + v + "'"
+}
+
+// columnType maps portable type names to the dialect; unknown types are kept as written.
+func (d dialect) columnType(t string) string {
+	base, args := t, ""
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		base, args = t[:i], t[i:]
+	}
+	switch strings.ToLower(strings.TrimSpace(base)) {
+	case "boolean", "bool":
+		if !d.isPostgres() {
+			return "tinyint(1)"
+		}
+		return "boolean"
+	case "datetime", "timestamp":
+		if d.isPostgres() {
+			return "timestamp" + args
+		}
+		return "datetime" + args
+	case "timestamptz":
+		if d.isPostgres() {
+			return "timestamptz" + args
+		}
+		return "datetime" + args
+	case "uuid":
+		if d.isPostgres() {
+			return "uuid"
+		}
+		return "char(36)"
+	case "blob", "bytea":
+		if d.isPostgres() {
+			return "bytea"
+		}
+		return "longblob"
+	case "clob", "text":
+		if d.isPostgres() {
+			return "text"
+		}
+		return "longtext"
+	case "double":
+		if d.isPostgres() {
+			return "double precision"
+		}
+		return "double"
+	}
+	return t
+}
@@ -0,0 +1,376 @@
+package baselith
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// exitDrift is the exit code of the diff command when the schemas differ.
+// Errors exit with 1.
+const exitDrift = 2
+
+// Kinds of schemaChange.
+const (
+	changeMissing = "missing" // only in the source
+	changeExtra   = "extra"   // only in the target
+	changeChanged = "changed"
+)
+
+// schemaChange is one difference between two snapshots.
+type schemaChange struct {
+	Object string `json:"object"` // table | column | index | constraint | sequence | view | function
+	Name   string `json:"name"`   // columns, indexes and constraints are prefixed with their table
+	Change string `json:"change"`
+	Field  string `json:"field,omitempty"` // the attribute that changed
+	Source string `json:"source,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// schemaDiff is the result of comparing a source schema with a target schema.
+type schemaDiff struct {
+	Source  string         `json:"source"`
+	Target  string         `json:"target"`
+	Drift   bool           `json:"drift"`
+	Changes []schemaChange `json:"changes"`
+}
+
+// differ accumulates the changes between two snapshots.
+type differ struct {
+	changes              []schemaChange
+	srcSchema, dstSchema string
+}
+
+func (df *differ) add(c schemaChange) {
+	df.changes = append(df.changes, c)
+}
+
+// field records a changed attribute when a and b differ.
+func (df *differ) field(object, name, field, a, b string) {
+	if a != b {
+		df.add(schemaChange{Object: object, Name: name, Change: changeChanged, Field: field, Source: a, Target: b})
+	}
+}
+
+// expression records a changed SQL expression, ignoring schema qualifiers and whitespace.
+func (df *differ) expression(object, name, field, a, b string) {
+	if normalizeDefinition(a, df.srcSchema) != normalizeDefinition(b, df.dstSchema) {
+		df.add(schemaChange{Object: object, Name: name, Change: changeChanged, Field: field, Source: a, Target: b})
+	}
+}
+
+// normalizeDefinition strips schema qualifiers and collapses whitespace so that
+// the same object in two schemas compares equal.
+func normalizeDefinition(def, schema string) string {
+	if schema != "" {
+		def = schemaQualifier(schema).ReplaceAllString(def, "")
+	}
+	return strings.Join(strings.Fields(def), " ")
+}
+
+// diffNames calls onlyA, onlyB and both for the names of a and b, in source order
+// then target order.
+func diffNames(a, b []string, onlyA, onlyB, both func(string)) {
+	inB := make(map[string]bool, len(b))
+	for _, n := range b {
+		inB[n] = true
+	}
+	inA := make(map[string]bool, len(a))
+	for _, n := range a {
+		inA[n] = true
+		if inB[n] {
+			both(n)
+		} else {
+			onlyA(n)
+		}
+	}
+	for _, n := range b {
+		if !inA[n] {
+			onlyB(n)
+		}
+	}
+}
+
+// diffSnapshots compares the source snapshot a with the target snapshot b.
+func diffSnapshots(a, b *schemaSnapshot) []schemaChange {
+	df := &differ{srcSchema: a.Schema, dstSchema: b.Schema}
+	missing := func(object, name string) { df.add(schemaChange{Object: object, Name: name, Change: changeMissing}) }
+	extra := func(object, name string) { df.add(schemaChange{Object: object, Name: name, Change: changeExtra}) }
+
+	seqA, seqB := make(map[string]sequenceSnapshot), make(map[string]sequenceSnapshot)
+	var seqNamesA, seqNamesB []string
+	for _, q := range a.Sequences {
+		seqA[q.Name], seqNamesA = q, append(seqNamesA, q.Name)
+	}
+	for _, q := range b.Sequences {
+		seqB[q.Name], seqNamesB = q, append(seqNamesB, q.Name)
+	}
+	diffNames(seqNamesA, seqNamesB,
+		func(n string) { missing("sequence", n) },
+		func(n string) { extra("sequence", n) },
+		func(n string) {
+			qa, qb := seqA[n], seqB[n]
+			df.field("sequence", n, "type", qa.Type, qb.Type)
+			df.field("sequence", n, "start", fmt.Sprint(qa.Start), fmt.Sprint(qb.Start))
+			df.field("sequence", n, "increment", fmt.Sprint(qa.Increment), fmt.Sprint(qb.Increment))
+			df.field("sequence", n, "min", fmt.Sprint(qa.Min), fmt.Sprint(qb.Min))
+			df.field("sequence", n, "max", fmt.Sprint(qa.Max), fmt.Sprint(qb.Max))
+			df.field("sequence", n, "cycle", fmt.Sprint(qa.Cycle), fmt.Sprint(qb.Cycle))
+		})
+
+	var tablesA, tablesB []string
+	for _, t := range a.Tables {
+		tablesA = append(tablesA, t.Name)
+	}
+	for _, t := range b.Tables {
+		tablesB = append(tablesB, t.Name)
+	}
+	diffNames(tablesA, tablesB,
+		func(n string) { missing("table", n) },
+		func(n string) { extra("table", n) },
+		func(n string) { df.table(a.table(n), b.table(n)) })
+
+	viewA, viewB := make(map[string]viewSnapshot), make(map[string]viewSnapshot)
+	var viewNamesA, viewNamesB []string
+	for _, v := range a.Views {
+		viewA[v.Name], viewNamesA = v, append(viewNamesA, v.Name)
+	}
+	for _, v := range b.Views {
+		viewB[v.Name], viewNamesB = v, append(viewNamesB, v.Name)
+	}
+	diffNames(viewNamesA, viewNamesB,
+		func(n string) { missing("view", n) },
+		func(n string) { extra("view", n) },
+		func(n string) {
+			df.field("view", n, "materialized", fmt.Sprint(viewA[n].Materialized), fmt.Sprint(viewB[n].Materialized))
+			df.expression("view", n, "definition", viewA[n].Definition, viewB[n].Definition)
+		})
+
+	fnA, fnB := make(map[string]functionSnapshot), make(map[string]functionSnapshot)
+	var fnNamesA, fnNamesB []string
+	for _, f := range a.Functions {
+		fnA[f.Name], fnNamesA = f, append(fnNamesA, f.Name)
+	}
+	for _, f := range b.Functions {
+		fnB[f.Name], fnNamesB = f, append(fnNamesB, f.Name)
+	}
+	diffNames(fnNamesA, fnNamesB,
+		func(n string) { missing("function", n) },
+		func(n string) { extra("function", n) },
+		func(n string) { df.expression("function", n, "definition", fnA[n].Definition, fnB[n].Definition) })
+
+	return df.changes
+}
+
+// table compares the columns, indexes and constraints of a table present on both sides.
+func (df *differ) table(a, b *tableSnapshot) {
+	missing := func(object, name string) { df.add(schemaChange{Object: object, Name: name, Change: changeMissing}) }
+	extra := func(object, name string) { df.add(schemaChange{Object: object, Name: name, Change: changeExtra}) }
+
+	colA, colB := make(map[string]columnSnapshot), make(map[string]columnSnapshot)
+	var colNamesA, colNamesB []string
+	for _, c := range a.Columns {
+		colA[c.Name], colNamesA = c, append(colNamesA, c.Name)
+	}
+	for _, c := range b.Columns {
+		colB[c.Name], colNamesB = c, append(colNamesB, c.Name)
+	}
+	diffNames(colNamesA, colNamesB,
+		func(n string) { missing("column", a.Name+"."+n) },
+		func(n string) { extra("column", a.Name+"."+n) },
+		func(n string) {
+			ca, cb := colA[n], colB[n]
+			name := a.Name + "." + n
+			df.field("column", name, "type", ca.Type, cb.Type)
+			df.field("column", name, "nullable", fmt.Sprint(ca.Nullable), fmt.Sprint(cb.Nullable))
+			df.expression("column", name, "default", defaultString(ca.Default), defaultString(cb.Default))
+			df.field("column", name, "identity", ca.Identity, cb.Identity)
+		})
+
+	ixA, ixB := make(map[string]indexSnapshot), make(map[string]indexSnapshot)
+	var ixNamesA, ixNamesB []string
+	for _, ix := range a.Indexes {
+		ixA[ix.Name], ixNamesA = ix, append(ixNamesA, ix.Name)
+	}
+	for _, ix := range b.Indexes {
+		ixB[ix.Name], ixNamesB = ix, append(ixNamesB, ix.Name)
+	}
+	diffNames(ixNamesA, ixNamesB,
+		func(n string) { missing("index", a.Name+"."+n) },
+		func(n string) { extra("index", a.Name+"."+n) },
+		func(n string) {
+			df.expression("index", a.Name+"."+n, "definition", ixA[n].Definition, ixB[n].Definition)
+		})
+
+	conA, conB := make(map[string]constraintSnapshot), make(map[string]constraintSnapshot)
+	var conNamesA, conNamesB []string
+	for _, c := range a.Constraints {
+		conA[c.Name], conNamesA = c, append(conNamesA, c.Name)
+	}
+	for _, c := range b.Constraints {
+		conB[c.Name], conNamesB = c, append(conNamesB, c.Name)
+	}
+	diffNames(conNamesA, conNamesB,
+		func(n string) { missing("constraint", a.Name+"."+n) },
+		func(n string) { extra("constraint", a.Name+"."+n) },
+		func(n string) {
+			df.expression("constraint", a.Name+"."+n, "definition", conA[n].Definition, conB[n].Definition)
+		})
+}
+
+func defaultString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// String renders a change as one line of the text report.
+func (c schemaChange) String() string {
+	switch c.Change {
+	case changeMissing:
+		return fmt.Sprintf("- %s %s: missing in target", c.Object, c.Name)
+	case changeExtra:
+		return fmt.Sprintf("+ %s %s: extra in target", c.Object, c.Name)
+	}
+	if c.Field == "definition" {
+		return fmt.Sprintf("~ %s %s: definition differs\n    source: %s\n    target: %s", c.Object, c.Name,
+			strings.Join(strings.Fields(c.Source), " "), strings.Join(strings.Fields(c.Target), " "))
+	}
+	return fmt.Sprintf("~ %s %s: %s %q -> %q", c.Object, c.Name, c.Field, c.Source, c.Target)
+}
+
+func (d *schemaDiff) write(w io.Writer) error {
+	switch DiffOutput {
+	case "json":
+		if d.Changes == nil {
+			d.Changes = []schemaChange{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case "", "text":
+		fmt.Fprintf(w, "source: %s\ntarget: %s\n", d.Source, d.Target)
+		for _, c := range d.Changes {
+			fmt.Fprintln(w, c)
+		}
+		if d.Drift {
+			_, err := fmt.Fprintf(w, "%d difference(s)\n", len(d.Changes))
+			return err
+		}
+		_, err := fmt.Fprintln(w, "no differences")
+		return err
+	default:
+		return fmt.Errorf("unsupported diff output: %s (text, json)", DiffOutput)
+	}
+}
+
+// readSnapshotFile reads a schema.json written by the snapshot command.
+func readSnapshotFile(path string) (*schemaSnapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var snap schemaSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	return &snap, nil
+}
+
+// snapshotDB connects to the database described by c and snapshots its schema.
+func snapshotDB(c *DBConfigYAML) (*schemaSnapshot, error) {
+	db, config, err := openDBFrom(c)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	defer sqlDB.Close()
+	return takeSnapshot(db, config.Driver, c.Schema)
+}
+
+// sourceSchema returns the schema of the configured connection: the config
+// file schema, or the changelog schema when there is none.
+func sourceSchema() (string, error) {
+	if Schema != "" {
+		return Schema, nil
+	}
+	doc, _, err := parseXML(Folder.JoinPath("migrations.xml"))
+	if err != nil {
+		return "", fmt.Errorf("no schema configured and %w", err)
+	}
+	return doc.Schema, nil
+}
+
+// loadDiffSnapshots snapshots the configured database (the source) and the
+// --target-config database or --target-snapshot file (the target).
+func loadDiffSnapshots() (source, target *schemaSnapshot, result *schemaDiff, err error) {
+	if (DiffTargetConfig == "") == (DiffTargetSnapshot == "") {
+		return nil, nil, nil, fmt.Errorf("exactly one of --target-config or --target-snapshot is required")
+	}
+	if err := loadConnectionConfig(); err != nil {
+		return nil, nil, nil, err
+	}
+	schema, err := sourceSchema()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	src := connectionConfig(schema)
+	if source, err = snapshotDB(src); err != nil {
+		return nil, nil, nil, err
+	}
+	result = &schemaDiff{Source: fmt.Sprintf("%s/%s.%s", src.Host, src.Dbname, schema)}
+
+	if DiffTargetSnapshot != "" {
+		target, err = readSnapshotFile(DiffTargetSnapshot)
+		result.Target = DiffTargetSnapshot
+	} else {
+		var dst *DBConfigYAML
+		if dst, err = readConfigYAMLFile(DiffTargetConfig); err == nil {
+			err = dst.applyURL()
+		}
+		if err == nil {
+			if dst.Schema == "" {
+				dst.Schema = schema
+			}
+			result.Target = fmt.Sprintf("%s/%s.%s", dst.Host, dst.Dbname, dst.Schema)
+			target, err = snapshotDB(dst)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if source.Driver != target.Driver {
+		return nil, nil, nil, fmt.Errorf("cannot compare a %s schema with a %s schema", source.Driver, target.Driver)
+	}
+	return source, target, result, nil
+}
+
+// RunDiff compares the configured database with another database or a snapshot
+// file and exits with exitDrift when they differ.
+func RunDiff(cmd *cobra.Command, _ []string) {
+	source, target, result, err := loadDiffSnapshots()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	result.Changes = diffSnapshots(source, target)
+	result.Drift = len(result.Changes) > 0
+	if err := result.write(cmd.OutOrStdout()); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if result.Drift {
+		os.Exit(exitDrift)
+	}
+}
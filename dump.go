@@ -0,0 +1,147 @@
+package baselith
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// snapshotFormats are the dump formats of the snapshot command, keyed by --snapshot-format name.
+var snapshotFormats = map[string]func(*schemaSnapshot) ([]byte, error){
+	"sql":  (*schemaSnapshot).renderSQL,
+	"json": (*schemaSnapshot).renderJSON,
+}
+
+func (s *schemaSnapshot) renderJSON() ([]byte, error) {
+	out := *s
+	if out.Tables == nil {
+		out.Tables = []tableSnapshot{}
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// renderSQL renders the snapshot as DDL. Foreign keys are added after every
+// table so that the dump does not depend on table order.
+func (s *schemaSnapshot) renderSQL() ([]byte, error) {
+	d, err := newDialect(s.Driver, s.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Schema %s (%s), generated by baselith snapshot. Do not edit.\n", s.Schema, d.driver)
+
+	if len(s.Sequences) > 0 {
+		b.WriteString("\n")
+	}
+	for _, q := range s.Sequences {
+		b.WriteString(d.createSequenceSQL(q) + "\n")
+	}
+
+	var foreignKeys []string
+	for _, t := range s.Tables {
+		b.WriteString("\n" + d.createTableSQL(t) + "\n")
+		for _, ix := range t.Indexes {
+			b.WriteString(d.createIndexSQL(ix) + "\n")
+		}
+		for _, c := range t.Constraints {
+			if c.Type == "FOREIGN KEY" {
+				foreignKeys = append(foreignKeys, d.addConstraintSQL(t.Name, c))
+			}
+		}
+	}
+	if len(foreignKeys) > 0 {
+		b.WriteString("\n" + strings.Join(foreignKeys, "\n") + "\n")
+	}
+
+	for _, v := range s.Views {
+		b.WriteString("\n" + d.createViewSQL(v) + "\n")
+	}
+	for _, f := range s.Functions {
+		b.WriteString("\n" + d.createFunctionSQL(f) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// writeSchemaSnapshot introspects schema and writes schema.<format> for every
+// --snapshot-format into --snapshot-dir, returning the written paths.
+func writeSchemaSnapshot(db *gorm.DB, driver, schema string) ([]string, error) {
+	formats := splitList(SnapshotFormat)
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("--snapshot-format is empty")
+	}
+	for _, f := range formats {
+		if _, ok := snapshotFormats[f]; !ok {
+			return nil, fmt.Errorf("unsupported snapshot format: %s (sql, json)", f)
+		}
+	}
+
+	snap, err := takeSnapshot(db, driver, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot schema %s: %w", schema, err)
+	}
+
+	dir := SnapshotDir
+	if dir == "" {
+		dir = Folder.Path()
+	}
+	var paths []string
+	for _, f := range formats {
+		b, err := snapshotFormats[f](snap)
+		if err != nil {
+			return paths, err
+		}
+		path := filepath.Join(dir, "schema."+f)
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			return paths, fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// RunSnapshot writes a deterministic dump of the configured schema next to the changelog.
+func RunSnapshot(cmd *cobra.Command, _ []string) {
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	doc, _, err := parseXML(Folder.JoinPath("migrations.xml"))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	Schema = doc.Schema
+
+	db, config, err := openDB(doc.Schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get database instance:", err)
+		return
+	}
+	defer sqlDB.Close()
+
+	paths, err := writeSchemaSnapshot(db, config.Driver, doc.Schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	for _, p := range paths {
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", p)
+	}
+}
@@ -0,0 +1,64 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hinha/baselith/persistence"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in printed settings.
+const redacted = "********"
+
+// RunEnvList prints every environment of the config file with its settings
+// resolved against the shared ones, secrets redacted.
+func RunEnvList(cmd *cobra.Command, _ []string) {
+	if ConfigPath == "" {
+		log.Fatal("env list: no config file, set --config")
+		return
+	}
+	f, err := readConfigFile(ConfigPath)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	out := cmd.OutOrStdout()
+	if len(f.environments) == 0 {
+		fmt.Fprintf(out, "no environments in %s\n", ConfigPath)
+		return
+	}
+	envs := make(map[string]map[string]any, len(f.environments))
+	for _, name := range f.names() {
+		settings, err := f.settings(ConfigPath, name)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		envs[name] = redactSettings(settings)
+	}
+	b, err := yaml.Marshal(envs)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	out.Write(b)
+}
+
+// redactSettings returns a copy of settings with the password replaced, also
+// in a connection URL.
+func redactSettings(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for k, v := range settings {
+		switch s, _ := v.(string); {
+		case k == "password" && v != nil && v != "":
+			v = redacted
+		case k == "url" && s != "":
+			v = persistence.RedactURL(s)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -1 +1 @@
-DROP TABLE IF EXISTS "user";
\ No newline at end of file
+DROP TABLE IF EXISTS ${schema}."user";
\ No newline at end of file
@@ -1,4 +1,4 @@
-CREATE TABLE IF NOT EXISTS public_test."user" (
+CREATE TABLE IF NOT EXISTS ${schema}."user" (
     id SERIAL PRIMARY KEY,
     username VARCHAR(50) NOT NULL UNIQUE,
     email VARCHAR(100) NOT NULL UNIQUE,
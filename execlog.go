@@ -0,0 +1,402 @@
+package baselith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// Outcomes recorded in schema_migrations_log.
+const (
+	outcomeRunning     = "running"
+	outcomeApplied     = "applied"
+	outcomeRolledBack  = "rolled_back"
+	outcomeFailed      = "failed"
+	outcomeInterrupted = "interrupted"
+	outcomeTimeout     = "timeout"
+	outcomeReverted    = "reverted" // applied in a transaction that was rolled back afterwards
+	outcomeRepaired    = "repaired"
+)
+
+// logRow is an execution recorded in schema_migrations_log.
+type logRow struct {
+	Seq             int64
+	ID              string
+	Direction       string
+	Outcome         string
+	Transactional   bool
+	Dirty           bool
+	Message         string
+	StatementIndex  *int
+	StartedAt       time.Time
+	DurationMs      *int64
+	ExecutedBy      string
+	ExecutedHost    string
+	BaselithVersion string
+}
+
+func (l logRow) String() string {
+	details := []string{l.Direction}
+	if l.StatementIndex != nil {
+		details = append(details, fmt.Sprintf("statement %d", *l.StatementIndex))
+	}
+	if l.DurationMs != nil {
+		details = append(details, (time.Duration(*l.DurationMs) * time.Millisecond).String())
+	}
+	details = append(details, l.ExecutedBy+"@"+l.ExecutedHost, "baselith "+l.BaselithVersion)
+	s := fmt.Sprintf("%s\t%s\t%s (%s)", l.StartedAt.Format(time.RFC3339), l.ID, strings.ToUpper(l.Outcome), strings.Join(details, ", "))
+	if l.Message != "" {
+		s += ": " + firstLine(l.Message)
+	}
+	if l.Dirty {
+		s += " [DIRTY]"
+	}
+	return s
+}
+
+// loadLogRows returns the entries of schema_migrations_log matching where, oldest first.
+func loadLogRows(db *gorm.DB, schema, where string, args ...any) ([]logRow, error) {
+	var rows []logRow
+	err := db.Raw(fmt.Sprintf(`SELECT seq, id, direction, outcome, transactional, dirty, COALESCE(message, '') AS message,
+       statement_index, started_at, duration_ms, executed_by, executed_host, baselith_version
+FROM %s.schema_migrations_log WHERE %s ORDER BY seq`, schema, where), args...).Scan(&rows).Error
+	return rows, err
+}
+
+// execLog records every changeset execution in schema_migrations_log. Entries
+// are written through the connection pool, outside the changeset transactions,
+// so that failed attempts are kept.
+type execLog struct {
+	db     *gorm.DB
+	d      dialect
+	schema string
+	in     *interrupter // nil outside a migration run
+	user   string
+	host   string
+
+	mu    sync.Mutex
+	batch []int64 // successful attempts of the running transactional batch
+}
+
+func newExecLog(db *gorm.DB, d dialect, schema string, in *interrupter) *execLog {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &execLog{db: db, d: d, schema: schema, in: in, user: executingUser(), host: host}
+}
+
+// executingUser returns the operating system user running baselith.
+func executingUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "unknown"
+}
+
+func (x *execLog) now() string {
+	if x.d.isPostgres() {
+		return "now()"
+	}
+	return "CURRENT_TIMESTAMP(6)"
+}
+
+// begin records the start of an execution and returns its seq.
+func (x *execLog) begin(id, direction string, transactional bool) (int64, error) {
+	insert := fmt.Sprintf(`INSERT INTO %s.schema_migrations_log
+	(id, direction, outcome, transactional, dirty, executed_by, executed_host, baselith_version)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, x.schema)
+	args := []any{id, direction, outcomeRunning, transactional, !transactional, x.user, x.host, Version}
+
+	var seq int64
+	var err error
+	if x.d.isPostgres() {
+		err = x.db.Raw(insert+" RETURNING seq", args...).Row().Scan(&seq)
+	} else {
+		err = x.db.Connection(func(c *gorm.DB) error {
+			if err := c.Exec(insert, args...).Error; err != nil {
+				return err
+			}
+			return c.Raw(`SELECT LAST_INSERT_ID()`).Row().Scan(&seq)
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to record %s %s in %s.schema_migrations_log: %w", direction, id, x.schema, err)
+	}
+	return seq, nil
+}
+
+// recordRepair records, through tx, that repair resolved the dirty execution of id.
+func (x *execLog) recordRepair(tx *gorm.DB, id, direction string, transactional bool) error {
+	err := tx.Exec(fmt.Sprintf(`INSERT INTO %s.schema_migrations_log
+	(id, direction, outcome, transactional, dirty, message, finished_at, duration_ms, executed_by, executed_host, baselith_version)
+	VALUES (?, ?, ?, ?, ?, ?, %s, ?, ?, ?, ?)`, x.schema, x.now()),
+		id, direction, outcomeRepaired, transactional, false, "marked "+RepairMark+" by repair", 0, x.user, x.host, Version).Error
+	if err != nil {
+		return fmt.Errorf("failed to record the repair of %s in %s.schema_migrations_log: %w", id, x.schema, err)
+	}
+	return nil
+}
+
+// finish records the outcome of the execution seq.
+func (x *execLog) finish(seq int64, outcome string, dirty bool, elapsed time.Duration, cause error) error {
+	var message *string
+	var index *int
+	if cause != nil {
+		m := cause.Error()
+		message = &m
+		var se *statementError
+		if errors.As(cause, &se) {
+			index = &se.Index
+		}
+	}
+	err := x.db.Exec(fmt.Sprintf(`UPDATE %s.schema_migrations_log
+	SET outcome = ?, dirty = ?, message = ?, statement_index = ?, finished_at = %s, duration_ms = ?
+	WHERE seq = ?`, x.schema, x.now()),
+		outcome, dirty, message, index, elapsed.Milliseconds(), seq).Error
+	if err != nil {
+		return fmt.Errorf("failed to record the outcome of execution %d in %s.schema_migrations_log: %w", seq, x.schema, err)
+	}
+	return nil
+}
+
+// attempt wraps fn so that each call is recorded. A non-transactional
+// execution stays dirty unless it succeeds.
+func (x *execLog) attempt(id, direction string, transactional bool, fn func(*gorm.DB) error) func(*gorm.DB) error {
+	if fn == nil {
+		return nil
+	}
+	return func(tx *gorm.DB) error {
+		seq, err := x.begin(id, direction, transactional)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		err = fn(tx)
+		outcome, dirty := outcomeApplied, false
+		if direction == "down" {
+			outcome = outcomeRolledBack
+		}
+		switch {
+		case errors.Is(err, errInterrupted):
+			// not started
+			outcome = outcomeInterrupted
+		case errors.As(err, new(*timeoutError)):
+			outcome, dirty = outcomeTimeout, !transactional
+		case err != nil && x.in != nil && x.in.interrupted():
+			outcome, dirty = outcomeInterrupted, !transactional
+		case err != nil:
+			outcome, dirty = outcomeFailed, !transactional
+		case transactional:
+			x.mu.Lock()
+			x.batch = append(x.batch, seq)
+			x.mu.Unlock()
+		}
+		if ferr := x.finish(seq, outcome, dirty, time.Since(start), err); ferr != nil {
+			log.Println(ferr)
+		}
+		if err == nil && !transactional {
+			// a successful retry supersedes the failed attempts before it
+			x.clean(id, seq)
+		}
+		return err
+	}
+}
+
+// clean clears the dirty state of the executions of id before seq.
+func (x *execLog) clean(id string, seq int64) {
+	err := x.db.Exec(fmt.Sprintf(`UPDATE %s.schema_migrations_log SET dirty = ? WHERE id = ? AND dirty AND seq < ?`, x.schema),
+		false, id, seq).Error
+	if err != nil {
+		log.Printf("Failed to clear the dirty state of %s: %v", id, err)
+	}
+}
+
+// wrap records the Migrate and Rollback calls of migs.
+func (x *execLog) wrap(migs []*gormigrate.Migration, transactional bool) []*gormigrate.Migration {
+	out := make([]*gormigrate.Migration, len(migs))
+	for i, gm := range migs {
+		out[i] = &gormigrate.Migration{
+			ID:       gm.ID,
+			Migrate:  x.attempt(gm.ID, "up", transactional, gm.Migrate),
+			Rollback: x.attempt(gm.ID, "down", transactional, gm.Rollback),
+		}
+	}
+	return out
+}
+
+// revertBatch marks the successful executions of the transactional batch as
+// reverted, after the batch transaction was rolled back.
+func (x *execLog) revertBatch() {
+	x.mu.Lock()
+	seqs := x.batch
+	x.batch = nil
+	x.mu.Unlock()
+	if len(seqs) == 0 {
+		return
+	}
+	err := x.db.Exec(fmt.Sprintf(`UPDATE %s.schema_migrations_log
+	SET outcome = ?, message = ? WHERE seq IN ?`, x.schema),
+		outcomeReverted, "rolled back with the transactional batch after a later failure", seqs).Error
+	if err != nil {
+		log.Printf("Failed to mark the transactional batch as reverted: %v", err)
+	}
+}
+
+// checkClean fails when a changeset is dirty.
+func (x *execLog) checkClean() error {
+	rows, err := loadLogRows(x.db, x.schema, "dirty")
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	l := rows[0]
+	return fmt.Errorf("changeset %s is dirty: a non-transactional %s did not complete (%s); check the database, then run `baselith repair %s --mark=completed|reverted`",
+		l.ID, l.Direction, l, l.ID)
+}
+
+// RunRepair lists the dirty changesets or, given an id, resolves one: with
+// --mark=completed its execution is recorded as finished, with --mark=reverted
+// as undone.
+func RunRepair(cmd *cobra.Command, args []string) {
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	doc, baseDir, err := loadMigrationsXML(Folder.JoinPath("migrations.xml"))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	Schema = doc.Schema
+
+	db, config, err := openDB(doc.Schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if err := migrationTable(config.Driver, NewDBAdapter(db)); err != nil {
+		log.Fatal("Failed to migration table:", err)
+		return
+	}
+
+	if err := repair(cmd, db, config.Driver, doc, baseDir, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func repair(cmd *cobra.Command, db *gorm.DB, driver string, doc *xmlMigrations, baseDir string, args []string) error {
+	out := cmd.OutOrStdout()
+	d, err := newDialect(driver, doc.Schema)
+	if err != nil {
+		return err
+	}
+
+	lock, err := newMigrationLock(db, driver, doc.Schema)
+	if err != nil {
+		return err
+	}
+	if err := lock.acquire(context.Background(), LockTimeout); err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.release(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	x := newExecLog(db, d, doc.Schema, nil)
+	dirty, err := loadLogRows(db, doc.Schema, "dirty")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if len(dirty) == 0 {
+			fmt.Fprintln(out, "No dirty changesets")
+		}
+		for _, l := range dirty {
+			fmt.Fprintln(out, l)
+		}
+		return nil
+	}
+
+	id := args[0]
+	var row *logRow
+	for i := range dirty {
+		if dirty[i].ID == id {
+			row = &dirty[i]
+		}
+	}
+	if row == nil {
+		return fmt.Errorf("changeset %s is not dirty", id)
+	}
+
+	var meta Meta
+	switch RepairMark {
+	case "completed":
+		if row.Direction == "up" {
+			_, _, metasTx, metasNoTx, _, err := readMigrationsXML(doc, baseDir)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if meta, ok = metasNoTx[id]; !ok {
+				meta, ok = metasTx[id]
+			}
+			if !ok {
+				return fmt.Errorf("changeset %s is not in the changelog", id)
+			}
+		}
+	case "reverted":
+		// the database is back to its state before the execution; nothing to record
+	default:
+		return fmt.Errorf("--mark=completed or --mark=reverted is required to repair %s", id)
+	}
+
+	// one transaction, so that a failure leaves the changeset dirty as it was
+	err = db.Transaction(func(tx *gorm.DB) error {
+		adapter := NewDBAdapter(tx)
+		switch {
+		case RepairMark == "completed" && row.Direction == "up":
+			if err := adapter.Exec(fmt.Sprintf(`INSERT INTO %s.schema_migrations (id) VALUES (?)`, doc.Schema), id).Error(); err != nil {
+				return err
+			}
+			if err := upsertMeta(adapter, doc.Schema, id, meta); err != nil {
+				return err
+			}
+		case RepairMark == "completed" && row.Direction == "down":
+			// the metadata is kept in the same row
+			if err := adapter.Exec(fmt.Sprintf(`DELETE FROM %s.schema_migrations WHERE id = ?`, doc.Schema), id).Error(); err != nil {
+				return err
+			}
+		}
+		if err := adapter.Exec(fmt.Sprintf(`UPDATE %s.schema_migrations_log SET dirty = ? WHERE id = ? AND dirty`, doc.Schema), false, id).Error(); err != nil {
+			return err
+		}
+		return x.recordRepair(tx, id, row.Direction, row.Transactional)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Marked %s %s of %s as %s\n", row.Outcome, row.Direction, id, RepairMark)
+	return nil
+}
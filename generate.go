@@ -0,0 +1,612 @@
+package baselith
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// schemaPlaceholder is the property generated SQL uses for the changelog schema.
+const schemaPlaceholder = "${schema}"
+
+// genChangeset is a generated "sql" changeset. Its down statements undo the
+// up statements in reverse order.
+type genChangeset struct {
+	slug   string // id suffix, e.g. create_table_m_roles
+	labels []string
+	up     []string
+	down   []string
+}
+
+// add appends up statements and prepends their inverse to the down statements.
+func (c *genChangeset) add(up, down []string) {
+	c.up = append(c.up, up...)
+	c.down = append(append([]string{}, down...), c.down...)
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns an object name into an id and label fragment.
+func slug(name string) string {
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i] // function arguments
+	}
+	return strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// nextChangelogNumber returns the numeric prefix following the highest one of
+// doc, as ordered by SortChangelogsByID, and the zero padded width to use.
+func nextChangelogNumber(doc *xmlMigrations) (next, width int) {
+	width = 3
+	for _, m := range doc.Items {
+		prefix := strings.SplitN(m.ID, "_", 2)[0]
+		n, err := strconv.Atoi(prefix)
+		if err != nil {
+			continue
+		}
+		if n >= next {
+			next = n
+		}
+		if len(prefix) > width {
+			width = len(prefix)
+		}
+	}
+	return next + 1, width
+}
+
+// defaultAuthor returns the git user name, falling back to $USER.
+func defaultAuthor() string {
+	if out, err := exec.Command("git", "config", "user.name").Output(); err == nil {
+		if name := strings.TrimSpace(string(out)); name != "" {
+			return name
+		}
+	}
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "unknown"
+}
+
+func xmlAttr(v string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(v))
+	return b.String()
+}
+
+// changelogEntry renders a <changeLog> element laid out like the hand written ones.
+// attrs are name/value pairs following id.
+func changelogEntry(indent, id string, attrs [][2]string, body []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s<changeLog id=\"%s\"", indent, xmlAttr(id))
+	align := indent + strings.Repeat(" ", len("<changeLog "))
+	for _, a := range attrs {
+		fmt.Fprintf(&b, "\n%s%s=\"%s\"", align, a[0], xmlAttr(a[1]))
+	}
+	b.WriteString(">\n")
+	for _, line := range body {
+		b.WriteString(indent + indent + line + "\n")
+	}
+	b.WriteString(indent + "</changeLog>\n")
+	return b.String()
+}
+
+var changelogIndent = regexp.MustCompile(`(?m)^([ \t]*)<changeLog\b`)
+
+// appendChangelogs inserts entries before </migrations>, leaving the rest of the
+// file untouched.
+func appendChangelogs(path string, render func(indent string) string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := string(b)
+	end := strings.LastIndex(content, "</migrations>")
+	if end < 0 {
+		return fmt.Errorf("%s: missing </migrations>", path)
+	}
+
+	indent := "    "
+	if m := changelogIndent.FindStringSubmatch(content); m != nil && m[1] != "" {
+		indent = m[1]
+	}
+	before := content[:end]
+	if !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	return os.WriteFile(path, []byte(before+render(indent)+content[end:]), 0o644)
+}
+
+// writeSQLFile creates a changeset SQL file, refusing to overwrite an existing one.
+func writeSQLFile(path string, stmts []string) error {
+	return createFile(path, strings.Join(stmts, "\n\n")+"\n")
+}
+
+// writeChangesets writes the SQL files of sets under changeset/ next to the
+// changelog at path and appends a <changeLog> for each, returning their ids.
+func writeChangesets(path string, doc *xmlMigrations, sets []genChangeset, author string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(path), "changeset")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	next, width := nextChangelogNumber(doc)
+	ids := make([]string, len(sets))
+	for i, c := range sets {
+		ids[i] = fmt.Sprintf("%0*d_%s", width, next+i, c.slug)
+		if err := writeSQLFile(filepath.Join(dir, ids[i]+".sql"), c.up); err != nil {
+			return nil, err
+		}
+		if err := writeSQLFile(filepath.Join(dir, ids[i]+".down.sql"), c.down); err != nil {
+			return nil, err
+		}
+	}
+
+	err := appendChangelogs(path, func(indent string) string {
+		var b strings.Builder
+		for i, c := range sets {
+			b.WriteString(changelogEntry(indent, ids[i],
+				[][2]string{{"kind", "sql"}, {"author", author}, {"labels", strings.Join(c.labels, ",")}},
+				[]string{
+					fmt.Sprintf(`<include file="./changeset/%s.sql" relativeToChangelogFile="true"/>`, ids[i]),
+					fmt.Sprintf(`<includeDown file="./changeset/%s.down.sql" relativeToChangelogFile="true"/>`, ids[i]),
+				}))
+		}
+		return b.String()
+	})
+	return ids, err
+}
+
+// createTableChangeset creates t with its indexes and the foreign keys not deferred.
+func createTableChangeset(d dialect, t tableSnapshot, deferred []constraintSnapshot) genChangeset {
+	c := genChangeset{slug: "create_table_" + slug(t.Name), labels: []string{slug(t.Name)}}
+	c.add([]string{d.createTableSQL(t)}, []string{d.dropTableSQL(t.Name)})
+	for _, ix := range t.Indexes {
+		c.add([]string{d.createIndexSQL(ix)}, nil) // dropped with the table
+	}
+	for _, fk := range t.Constraints {
+		if fk.Type == "FOREIGN KEY" && !containsConstraint(deferred, fk.Name) {
+			c.add([]string{d.addConstraintSQL(t.Name, fk)}, nil)
+		}
+	}
+	return c
+}
+
+func containsConstraint(list []constraintSnapshot, name string) bool {
+	for _, c := range list {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// objectName splits a "table.object" change name.
+func objectName(table, name string) string {
+	return strings.TrimPrefix(name, table+".")
+}
+
+// changesetsFromDiff returns the changesets transforming tgt into ref. Both
+// snapshots must use the same schema qualifier, see requalify.
+func changesetsFromDiff(d dialect, ref, tgt *schemaSnapshot) []genChangeset {
+	changes := diffSnapshots(ref, tgt)
+
+	var (
+		seqSets, sets              []genChangeset
+		seqDrops, drops            []genChangeset // views before the functions they may use
+		missingTables, extraTables []tableSnapshot
+		tableOrder                 []string
+		tableChanges               = make(map[string][]schemaChange)
+		changedSeqs, changedViews  = make(map[string]bool), make(map[string]bool)
+		changedFuncs               = make(map[string]bool)
+	)
+	for _, ch := range changes {
+		switch ch.Object {
+		case "sequence":
+			switch ch.Change {
+			case changeMissing:
+				q := findSequence(ref, ch.Name)
+				c := genChangeset{slug: "create_sequence_" + slug(q.Name), labels: []string{slug(q.Name)}}
+				c.add([]string{d.createSequenceSQL(q)}, []string{d.dropSequenceSQL(q.Name)})
+				seqSets = append(seqSets, c)
+			case changeExtra:
+				q := findSequence(tgt, ch.Name)
+				c := genChangeset{slug: "drop_sequence_" + slug(q.Name), labels: []string{slug(q.Name)}}
+				c.add([]string{d.dropSequenceSQL(q.Name)}, []string{d.createSequenceSQL(q)})
+				seqDrops = append(seqDrops, c)
+			default:
+				if !changedSeqs[ch.Name] {
+					changedSeqs[ch.Name] = true
+					c := genChangeset{slug: "alter_sequence_" + slug(ch.Name), labels: []string{slug(ch.Name)}}
+					c.add([]string{d.alterSequenceSQL(findSequence(ref, ch.Name))}, []string{d.alterSequenceSQL(findSequence(tgt, ch.Name))})
+					seqSets = append(seqSets, c)
+				}
+			}
+		case "table":
+			if ch.Change == changeMissing {
+				missingTables = append(missingTables, *ref.table(ch.Name))
+			} else {
+				extraTables = append(extraTables, *tgt.table(ch.Name))
+			}
+		case "column", "index", "constraint":
+			table := strings.SplitN(ch.Name, ".", 2)[0]
+			if _, ok := tableChanges[table]; !ok {
+				tableOrder = append(tableOrder, table)
+			}
+			tableChanges[table] = append(tableChanges[table], ch)
+		case "view":
+			var c genChangeset
+			switch ch.Change {
+			case changeMissing:
+				v := findView(ref, ch.Name)
+				c = genChangeset{slug: "create_view_" + slug(v.Name), labels: []string{slug(v.Name)}}
+				c.add([]string{d.createViewSQL(v)}, []string{d.dropViewSQL(v)})
+			case changeExtra:
+				v := findView(tgt, ch.Name)
+				c = genChangeset{slug: "drop_view_" + slug(v.Name), labels: []string{slug(v.Name)}}
+				c.add([]string{d.dropViewSQL(v)}, []string{d.createViewSQL(v)})
+				drops = append(drops, c)
+				continue
+			default:
+				if changedViews[ch.Name] {
+					continue
+				}
+				changedViews[ch.Name] = true
+				from, to := findView(tgt, ch.Name), findView(ref, ch.Name)
+				c = genChangeset{slug: "replace_view_" + slug(ch.Name), labels: []string{slug(ch.Name)}}
+				c.add([]string{d.dropViewSQL(from)}, []string{d.createViewSQL(from)})
+				c.add([]string{d.createViewSQL(to)}, []string{d.dropViewSQL(to)})
+			}
+			sets = append(sets, c)
+		case "function":
+			var c genChangeset
+			switch ch.Change {
+			case changeMissing:
+				f := findFunction(ref, ch.Name)
+				c = genChangeset{slug: "create_" + strings.ToLower(f.Kind) + "_" + slug(f.Name), labels: []string{slug(f.Name)}}
+				c.add([]string{d.createFunctionSQL(f)}, []string{d.dropFunctionSQL(f)})
+			case changeExtra:
+				f := findFunction(tgt, ch.Name)
+				c = genChangeset{slug: "drop_" + strings.ToLower(f.Kind) + "_" + slug(f.Name), labels: []string{slug(f.Name)}}
+				c.add([]string{d.dropFunctionSQL(f)}, []string{d.createFunctionSQL(f)})
+				drops = append(drops, c)
+				continue
+			default:
+				if changedFuncs[ch.Name] {
+					continue
+				}
+				changedFuncs[ch.Name] = true
+				from, to := findFunction(tgt, ch.Name), findFunction(ref, ch.Name)
+				c = genChangeset{slug: "replace_" + strings.ToLower(to.Kind) + "_" + slug(to.Name), labels: []string{slug(to.Name)}}
+				if d.isPostgres() {
+					// pg_get_functiondef renders CREATE OR REPLACE
+					c.add([]string{d.createFunctionSQL(to)}, []string{d.createFunctionSQL(from)})
+				} else {
+					c.add([]string{d.dropFunctionSQL(from)}, []string{d.createFunctionSQL(from)})
+					c.add([]string{d.createFunctionSQL(to)}, []string{d.dropFunctionSQL(to)})
+				}
+			}
+			sets = append(sets, c)
+		}
+	}
+
+	// sequences come first as column defaults may use them, and new tables
+	// before the views and functions that may use them
+	tableSets := seqSets
+	ordered, deferred := foreignKeyOrder(missingTables)
+	for _, t := range ordered {
+		tableSets = append(tableSets, createTableChangeset(d, t, deferred[t.Name]))
+	}
+	if len(deferred) > 0 {
+		c := genChangeset{slug: "add_foreign_keys"}
+		for _, t := range ordered {
+			for _, fk := range deferred[t.Name] {
+				c.add([]string{d.addConstraintSQL(t.Name, fk)}, []string{d.dropConstraintSQL(t.Name, fk)})
+				if !contains(c.labels, slug(t.Name)) {
+					c.labels = append(c.labels, slug(t.Name))
+				}
+			}
+		}
+		tableSets = append(tableSets, c)
+	}
+	for _, table := range tableOrder {
+		if c, ok := alterTableChangeset(d, ref.table(table), tgt.table(table), tableChanges[table]); ok {
+			tableSets = append(tableSets, c)
+		}
+	}
+
+	out := append(tableSets, sets...)
+
+	// extra tables are dropped dependents first; rolling back recreates them in order
+	ordered, _ = foreignKeyOrder(extraTables)
+	var tableDrops []genChangeset
+	for _, t := range ordered {
+		c := createTableChangeset(d, t, nil)
+		c.slug, c.up, c.down = "drop_table_"+slug(t.Name), c.down, c.up
+		tableDrops = append([]genChangeset{c}, tableDrops...)
+	}
+	// views and functions, then tables, then sequences
+	out = append(out, drops...)
+	out = append(out, tableDrops...)
+	return append(out, seqDrops...)
+}
+
+// alterTableChangeset turns the column, index and constraint changes of a
+// table into one changeset: constraints and indexes are dropped first, then
+// columns change, then constraints and indexes are added.
+func alterTableChangeset(d dialect, ref, tgt *tableSnapshot, changes []schemaChange) (genChangeset, bool) {
+	c := genChangeset{slug: "alter_table_" + slug(ref.Name), labels: []string{slug(ref.Name)}}
+	var dropping, altering, adding genChangeset
+	altered := make(map[string]bool)
+
+	for _, ch := range changes {
+		name := objectName(ref.Name, ch.Name)
+		switch ch.Object {
+		case "column":
+			switch ch.Change {
+			case changeMissing:
+				col := findColumn(ref, name)
+				altering.add([]string{d.addColumnSQL(ref.Name, col)}, []string{d.dropColumnSQL(ref.Name, name)})
+			case changeExtra:
+				col := findColumn(tgt, name)
+				altering.add([]string{d.dropColumnSQL(ref.Name, name)}, []string{d.addColumnSQL(ref.Name, col)})
+			default:
+				if altered[name] {
+					continue
+				}
+				altered[name] = true
+				from, to := findColumn(tgt, name), findColumn(ref, name)
+				altering.add(d.alterColumnSQL(ref.Name, from, to), d.alterColumnSQL(ref.Name, to, from))
+			}
+		case "index":
+			if ch.Change != changeMissing {
+				ix := findIndex(tgt, name)
+				dropping.add([]string{d.dropIndexSQL(ref.Name, name)}, []string{d.createIndexSQL(ix)})
+			}
+			if ch.Change != changeExtra {
+				ix := findIndex(ref, name)
+				adding.add([]string{d.createIndexSQL(ix)}, []string{d.dropIndexSQL(ref.Name, name)})
+			}
+		case "constraint":
+			if ch.Change != changeMissing {
+				con := findConstraint(tgt, name)
+				dropping.add([]string{d.dropConstraintSQL(ref.Name, con)}, []string{d.addConstraintSQL(ref.Name, con)})
+			}
+			if ch.Change != changeExtra {
+				con := findConstraint(ref, name)
+				adding.add([]string{d.addConstraintSQL(ref.Name, con)}, []string{d.dropConstraintSQL(ref.Name, con)})
+			}
+		}
+	}
+	for _, step := range []genChangeset{dropping, altering, adding} {
+		c.add(step.up, step.down)
+	}
+	return c, len(c.up) > 0
+}
+
+func findSequence(s *schemaSnapshot, name string) sequenceSnapshot {
+	for _, q := range s.Sequences {
+		if q.Name == name {
+			return q
+		}
+	}
+	return sequenceSnapshot{Name: name}
+}
+
+func findView(s *schemaSnapshot, name string) viewSnapshot {
+	for _, v := range s.Views {
+		if v.Name == name {
+			return v
+		}
+	}
+	return viewSnapshot{Name: name}
+}
+
+func findFunction(s *schemaSnapshot, name string) functionSnapshot {
+	for _, f := range s.Functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return functionSnapshot{Name: name}
+}
+
+func findColumn(t *tableSnapshot, name string) columnSnapshot {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return columnSnapshot{Name: name}
+}
+
+func findIndex(t *tableSnapshot, name string) indexSnapshot {
+	for _, ix := range t.Indexes {
+		if ix.Name == name {
+			return ix
+		}
+	}
+	return indexSnapshot{Name: name}
+}
+
+func findConstraint(t *tableSnapshot, name string) constraintSnapshot {
+	for _, c := range t.Constraints {
+		if c.Name == name {
+			return c
+		}
+	}
+	return constraintSnapshot{Name: name}
+}
+
+// RunDiffChangelog appends changesets to the changelog that transform the
+// target database or snapshot into the configured (reference) database.
+func RunDiffChangelog(cmd *cobra.Command, _ []string) {
+	source, target, _, err := loadDiffSnapshots()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	path := Folder.JoinPath("migrations.xml")
+	doc, _, err := parseXML(path)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	d, err := newDialect(source.Driver, schemaPlaceholder)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	sets := changesetsFromDiff(d, source.requalify(schemaPlaceholder), target.requalify(schemaPlaceholder))
+	out := cmd.OutOrStdout()
+	if len(sets) == 0 {
+		fmt.Fprintln(out, "no differences")
+		return
+	}
+
+	author := ChangelogAuthor
+	if author == "" {
+		author = defaultAuthor()
+	}
+	ids, err := writeChangesets(path, doc, sets, author)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	for _, id := range ids {
+		fmt.Fprintf(out, "Added %s\n", id)
+	}
+}
+
+const changelogSkeleton = `<?xml version="1.0" encoding="UTF-8"?>
+<migrations xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+            xsi:noNamespaceSchemaLocation="https://raw.githubusercontent.com/hinha/baselith/main/xsd/baselith-changelog-%s.xsd"
+            schema="%s" version="%s">
+</migrations>
+`
+
+// defaultSchema returns the configured schema, or the driver's default one.
+func defaultSchema() string {
+	if Schema != "" {
+		return Schema
+	}
+	if d, err := newDialect(Driver, ""); err == nil && !d.isPostgres() {
+		return Dbname
+	}
+	return "public"
+}
+
+// baselineChangesets records ids as applied in schema_migrations without running them.
+func baselineChangesets(db *gorm.DB, schema string, ids []string, sets []genChangeset, author string) (int, error) {
+	var applied []string
+	if err := db.Raw(fmt.Sprintf("SELECT id FROM %s.schema_migrations", schema)).Scan(&applied).Error; err != nil {
+		return 0, err
+	}
+	recorded := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			if contains(applied, id) {
+				continue
+			}
+			if err := tx.Exec(fmt.Sprintf(`INSERT INTO %s.schema_migrations (id, author, labels, kind, transactional)
+				VALUES (?, ?, ?, ?, ?)`, schema), id, author, strings.Join(sets[i].labels, ","), "sql", true).Error; err != nil {
+				return fmt.Errorf("failed to baseline %s: %w", id, err)
+			}
+			recorded++
+		}
+		return nil
+	})
+	return recorded, err
+}
+
+// RunGenerateChangelog reverse-engineers the schema of an existing database into
+// a new changelog, optionally marking the generated changesets as applied.
+func RunGenerateChangelog(cmd *cobra.Command, _ []string) {
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	path := Folder.JoinPath("migrations.xml")
+	if _, err := os.Stat(path); err == nil {
+		log.Fatalf("%s already exists; use diff-changelog to extend an existing changelog", path)
+		return
+	}
+
+	schema := defaultSchema()
+	db, config, err := openDB(schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get database instance:", err)
+		return
+	}
+	defer sqlDB.Close()
+
+	snap, err := takeSnapshot(db, config.Driver, schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	d, err := newDialect(config.Driver, schemaPlaceholder)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	// everything in the schema is missing from an empty one
+	empty := &schemaSnapshot{Driver: snap.Driver, Schema: schemaPlaceholder}
+	sets := changesetsFromDiff(d, snap.requalify(schemaPlaceholder), empty)
+	if len(sets) == 0 {
+		log.Fatalf("schema %s has no objects to generate a changelog from", schema)
+		return
+	}
+
+	if err := os.MkdirAll(Folder.Path(), 0o755); err != nil {
+		log.Fatal(err)
+		return
+	}
+	skeleton := fmt.Sprintf(changelogSkeleton, ChangelogVersion, xmlAttr(schema), ChangelogVersion)
+	if err := os.WriteFile(path, []byte(skeleton), 0o644); err != nil {
+		log.Fatal(err)
+		return
+	}
+	author := ChangelogAuthor
+	if author == "" {
+		author = defaultAuthor()
+	}
+	ids, err := writeChangesets(path, &xmlMigrations{Schema: schema}, sets, author)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Wrote %s with %d changeset(s)\n", path, len(ids))
+
+	if !GenerateBaseline {
+		return
+	}
+	Schema = schema
+	if err := migrationTable(config.Driver, NewDBAdapter(db)); err != nil {
+		log.Fatal("Failed to migration table:", err)
+		return
+	}
+	n, err := baselineChangesets(db, schema, ids, sets, author)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	fmt.Fprintf(out, "Baselined %d changeset(s) up to %s\n", n, ids[len(ids)-1])
+}
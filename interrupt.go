@@ -0,0 +1,170 @@
+package baselith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// exitInterrupted is the exit code of a migration run stopped by SIGINT or SIGTERM.
+const exitInterrupted = 130
+
+// errInterrupted is returned by changesets that were not started because the run was interrupted.
+var errInterrupted = errors.New("migration run interrupted")
+
+// interrupter stops a migration run on SIGINT/SIGTERM. The first signal stops
+// the run before the next changeset and ends any wait; the second cancels the
+// running statement server-side and the context of the run.
+type interrupter struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wait is cancelled by the first signal, for waits that hold up the run
+	// without doing work: the migration lock and retry backoffs
+	wait        context.Context
+	stopWaiting context.CancelFunc
+	db          *gorm.DB // connection pool used for cancel requests
+	d           dialect
+	sig         chan os.Signal
+
+	mu       sync.Mutex
+	stopping bool
+	current  string // id of the running (or last started) changeset
+	backend  int64  // server session of the running changeset, 0 when unknown
+}
+
+// watchInterrupts installs the signal handler; stop removes it.
+func watchInterrupts(db *gorm.DB, driver, schema string) (*interrupter, error) {
+	d, err := newDialect(driver, schema)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	in := &interrupter{ctx: ctx, cancel: cancel, db: db, d: d, sig: make(chan os.Signal, 2)}
+	in.wait, in.stopWaiting = context.WithCancel(ctx)
+	signal.Notify(in.sig, os.Interrupt, syscall.SIGTERM)
+	go in.loop()
+	return in, nil
+}
+
+func (in *interrupter) loop() {
+	for {
+		select {
+		case <-in.ctx.Done():
+			return
+		case s := <-in.sig:
+			in.mu.Lock()
+			first := !in.stopping
+			in.stopping = true
+			id, backend := in.current, in.backend
+			in.mu.Unlock()
+
+			if first {
+				in.stopWaiting()
+				log.Printf("Received %s: stopping after the running statement, send it again to cancel the statement", s)
+				continue
+			}
+			log.Printf("Received %s again: cancelling %s", s, id)
+			if backend != 0 {
+				if err := in.cancelBackend(backend); err != nil {
+					log.Printf("Failed to cancel the running statement: %v", err)
+				}
+			}
+			in.cancel()
+			return
+		}
+	}
+}
+
+// cancelBackend asks the server to cancel the statement running in session id.
+func (in *interrupter) cancelBackend(id int64) error {
+	db := in.db.WithContext(context.Background())
+	if in.d.isPostgres() {
+		var ok bool
+		return db.Raw(`SELECT pg_cancel_backend(?)`, id).Scan(&ok).Error
+	}
+	return db.Exec(fmt.Sprintf("KILL QUERY %d", id)).Error
+}
+
+func (in *interrupter) stop() {
+	signal.Stop(in.sig)
+	in.cancel()
+}
+
+// interrupted reports whether a signal was received.
+func (in *interrupter) interrupted() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.stopping
+}
+
+// guard wraps fn so that it is not started once the run is interrupted, and so
+// that its server session is known for a server-side cancel. Outside a
+// transaction the changeset is pinned to one connection.
+func (in *interrupter) guard(id string, fn func(*gorm.DB) error) func(*gorm.DB) error {
+	if fn == nil {
+		return nil
+	}
+	run := func(tx *gorm.DB) error {
+		var backend int64
+		query := `SELECT CONNECTION_ID()`
+		if in.d.isPostgres() {
+			query = `SELECT pg_backend_pid()`
+		}
+		if err := tx.Raw(query).Row().Scan(&backend); err != nil {
+			return err
+		}
+
+		in.mu.Lock()
+		if in.stopping {
+			in.mu.Unlock()
+			return errInterrupted
+		}
+		in.current, in.backend = id, backend
+		in.mu.Unlock()
+
+		err := fn(tx)
+
+		in.mu.Lock()
+		in.backend = 0
+		in.mu.Unlock()
+		return err
+	}
+	return func(tx *gorm.DB) error {
+		if inTransaction(tx) {
+			return run(tx)
+		}
+		return tx.Connection(run)
+	}
+}
+
+// wrap guards the Migrate and Rollback functions of migs.
+func (in *interrupter) wrap(migs []*gormigrate.Migration) []*gormigrate.Migration {
+	out := make([]*gormigrate.Migration, len(migs))
+	for i, gm := range migs {
+		out[i] = &gormigrate.Migration{
+			ID:       gm.ID,
+			Migrate:  in.guard(gm.ID, gm.Migrate),
+			Rollback: in.guard(gm.ID, gm.Rollback),
+		}
+	}
+	return out
+}
+
+// err returns errInterrupted, wrapping cause, when the run was interrupted.
+func (in *interrupter) err(cause error) error {
+	if !in.interrupted() {
+		return cause
+	}
+	if cause == nil || errors.Is(cause, errInterrupted) {
+		return errInterrupted
+	}
+	return fmt.Errorf("%w: %v", errInterrupted, cause)
+}
@@ -0,0 +1,520 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Lint severities, from most to least severe.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+var severityRank = map[string]int{
+	severityError:   3,
+	severityWarning: 2,
+	severityInfo:    1,
+}
+
+// statement is a single SQL statement and the line it starts on.
+type statement struct {
+	SQL  string
+	Line int
+}
+
+// lintTarget is the SQL of one changeset as seen by the linter.
+type lintTarget struct {
+	ID            string
+	File          string // SQL file, or the changelog for generated SQL
+	Statements    []statement
+	Transactional bool
+	Ignore        []string
+}
+
+// lintRule inspects one statement. normalized is the statement without comments,
+// with collapsed whitespace and in upper case.
+type lintRule struct {
+	ID          string
+	Severity    string
+	Drivers     []string // empty = every driver
+	Description string
+	Check       func(t lintTarget, normalized string) bool
+}
+
+// lintFinding is a rule violation.
+type lintFinding struct {
+	Rule     string
+	Severity string
+	File     string
+	Line     int
+	ID       string
+	Message  string
+}
+
+func (f lintFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s %s: %s: %s", f.File, f.Line, f.Severity, f.Rule, f.ID, f.Message)
+}
+
+var (
+	reAddClause      = regexp.MustCompile(`\bADD\b`)
+	reAlterTable     = regexp.MustCompile(`^ALTER TABLE\b`)
+	reCreateIndex    = regexp.MustCompile(`^CREATE (UNIQUE )?INDEX\b`)
+	reConcurrently   = regexp.MustCompile(`\bCONCURRENTLY\b`)
+	reDropClause     = regexp.MustCompile(`\bDROP (\w+)`)
+	reDropTable      = regexp.MustCompile(`^DROP TABLE\b`)
+	reRename         = regexp.MustCompile(`^ALTER TABLE\b.*\bRENAME\b|^RENAME TABLE\b`)
+	reAlterType      = regexp.MustCompile(`^ALTER TABLE\b.*\b(ALTER (COLUMN )?\S+ (SET DATA )?TYPE|MODIFY|CHANGE)\b`)
+	reForeignKey     = regexp.MustCompile(`^ALTER TABLE\b.*\bFOREIGN KEY\b`)
+	reNotValid       = regexp.MustCompile(`\bNOT VALID\b`)
+	reDDL            = regexp.MustCompile(`^(CREATE|ALTER|DROP|RENAME|TRUNCATE)\b`)
+	reAddConstraints = regexp.MustCompile(`^(CONSTRAINT|INDEX|KEY|PRIMARY|FOREIGN|UNIQUE|CHECK)\b`)
+)
+
+// dropKeywords follow DROP in ALTER TABLE clauses that do not drop a column,
+// such as DROP CONSTRAINT or ALTER COLUMN c DROP NOT NULL.
+var dropKeywords = map[string]bool{
+	"CONSTRAINT": true, "FOREIGN": true, "INDEX": true, "KEY": true, "PRIMARY": true, "CHECK": true,
+	"DEFAULT": true, "NOT": true, "EXPRESSION": true, "IDENTITY": true, "PARTITION": true, "SYSTEM": true, "PERIOD": true,
+}
+
+// lintRules is the rule set applied by the lint command.
+var lintRules = []lintRule{
+	{
+		ID:          "add-column-not-null-no-default",
+		Severity:    severityError,
+		Description: "ADD COLUMN ... NOT NULL without DEFAULT fails on tables that already have rows",
+		Check: func(_ lintTarget, s string) bool {
+			if !reAlterTable.MatchString(s) {
+				return false
+			}
+			for _, clause := range reAddClause.Split(s, -1)[1:] {
+				clause = strings.TrimPrefix(strings.TrimSpace(clause), "COLUMN ")
+				if reAddConstraints.MatchString(clause) {
+					continue
+				}
+				if strings.Contains(clause, "NOT NULL") && !strings.Contains(clause, "DEFAULT") &&
+					!strings.Contains(clause, "IDENTITY") && !strings.Contains(clause, "AUTO_INCREMENT") {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		ID:          "create-index-not-concurrent",
+		Severity:    severityWarning,
+		Drivers:     []string{"postgres"},
+		Description: "CREATE INDEX without CONCURRENTLY blocks writes to the table while the index is built",
+		Check: func(_ lintTarget, s string) bool {
+			return reCreateIndex.MatchString(s) && !reConcurrently.MatchString(s)
+		},
+	},
+	{
+		ID:          "concurrently-in-transaction",
+		Severity:    severityError,
+		Drivers:     []string{"postgres"},
+		Description: "CONCURRENTLY cannot run inside a transaction; mark the changeset transactional=\"false\"",
+		Check: func(t lintTarget, s string) bool {
+			return t.Transactional && reConcurrently.MatchString(s)
+		},
+	},
+	{
+		ID:          "ddl-in-transaction",
+		Severity:    severityInfo,
+		Drivers:     []string{"mysql"},
+		Description: "MySQL commits DDL implicitly, so a transactional changeset is not rolled back on failure",
+		Check: func(t lintTarget, s string) bool {
+			return t.Transactional && reDDL.MatchString(s)
+		},
+	},
+	{
+		ID:          "drop-column",
+		Severity:    severityWarning,
+		Description: "DROP COLUMN breaks code still reading the column; drop it in a release after the code change",
+		Check: func(_ lintTarget, s string) bool {
+			if !reAlterTable.MatchString(s) {
+				return false
+			}
+			// DROP COLUMN c, or DROP c when c is not a keyword (COLUMN is optional)
+			for _, m := range reDropClause.FindAllStringSubmatch(s, -1) {
+				if m[1] == "COLUMN" || !dropKeywords[m[1]] {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		ID:          "drop-table",
+		Severity:    severityWarning,
+		Description: "DROP TABLE is irreversible and breaks code still using the table",
+		Check: func(_ lintTarget, s string) bool {
+			return reDropTable.MatchString(s)
+		},
+	},
+	{
+		ID:          "rename",
+		Severity:    severityWarning,
+		Description: "renaming a table or column breaks code using the old name",
+		Check: func(_ lintTarget, s string) bool {
+			return reRename.MatchString(s)
+		},
+	},
+	{
+		ID:          "alter-column-type",
+		Severity:    severityWarning,
+		Description: "changing a column type may rewrite the table under an exclusive lock",
+		Check: func(_ lintTarget, s string) bool {
+			return reAlterType.MatchString(s)
+		},
+	},
+	{
+		ID:          "add-foreign-key-validated",
+		Severity:    severityWarning,
+		Drivers:     []string{"postgres"},
+		Description: "adding a validated foreign key scans the table under lock; add it NOT VALID and validate separately",
+		Check: func(_ lintTarget, s string) bool {
+			return reForeignKey.MatchString(s) && strings.Contains(s, "ADD ") && !reNotValid.MatchString(s)
+		},
+	},
+}
+
+// splitStatements splits a SQL script on top level semicolons, honouring quotes,
+// comments and postgres dollar quoting, and records the line each statement starts on.
+// The BEGIN ... END body of a procedure, function, trigger or event stays in one statement.
+func splitStatements(sql string) []statement {
+	stmts, _ := scanStatements(sql)
+	return stmts
+}
+
+// routineKinds are the objects whose definition may hold a BEGIN ... END body.
+var routineKinds = map[string]bool{"PROCEDURE": true, "FUNCTION": true, "TRIGGER": true, "EVENT": true}
+
+// blockEnds are the words that close a compound statement other than BEGIN ... END or CASE.
+var blockEnds = map[string]bool{"IF": true, "LOOP": true, "WHILE": true, "REPEAT": true}
+
+// scanStatements does the work of splitStatements. exact is false when a quoted
+// string contains a backslash, which escapes the next character in MySQL and
+// postgres E” strings but not in standard postgres strings, so the boundaries
+// may differ from those of the server.
+func scanStatements(sql string) (out []statement, exact bool) {
+	var (
+		start     = 0
+		startLine = 1
+		line      = 1
+		words     = 0     // words read in the current statement
+		create    = false // the current statement starts with CREATE
+		routine   = false // the current statement defines a routine
+		depth     = 0     // open BEGIN and CASE blocks of the routine
+	)
+	exact = true
+	flush := func(end int) {
+		text := strings.TrimSpace(sql[start:end])
+		if text != "" {
+			// the statement starts at its first character that is neither blank nor a comment
+			lead := sql[start:end]
+			offset := strings.Count(lead[:leadingTrivia(lead)], "\n")
+			out = append(out, statement{SQL: text, Line: startLine + offset})
+		}
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			line++
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+			line += strings.Count(sql[i:i+2+end], "\n")
+			i += end + 3
+		case c == '\'' || c == '"' || c == '`':
+			escaped := c == '\'' && i > start && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i-1 == start || !isWordByte(sql[i-2]))
+			for i++; i < len(sql) && sql[i] != c; i++ {
+				if sql[i] == '\\' && c != '`' {
+					if !escaped {
+						exact = false
+					}
+					i++
+				}
+				if i < len(sql) && sql[i] == '\n' {
+					line++
+				}
+			}
+		case c == '$':
+			// dollar quoted string: $tag$ ... $tag$
+			end := strings.IndexByte(sql[i+1:], '$')
+			if end < 0 || strings.ContainsAny(sql[i+1:i+1+end], " \t\r\n;") {
+				continue
+			}
+			tag := sql[i : i+end+2]
+			closing := strings.Index(sql[i+len(tag):], tag)
+			if closing < 0 {
+				continue
+			}
+			line += strings.Count(sql[i:i+len(tag)+closing], "\n")
+			i += len(tag) + closing + len(tag) - 1
+		case isWordByte(c) && (i == 0 || !isWordByte(sql[i-1])):
+			end := i
+			for end < len(sql) && isWordByte(sql[end]) {
+				end++
+			}
+			word := strings.ToUpper(sql[i:end])
+			i = end - 1
+			words++
+			switch {
+			case words == 1:
+				create = word == "CREATE"
+			case create && !routine && words <= 8 && routineKinds[word]:
+				routine = true
+			case routine && (word == "BEGIN" || word == "CASE"):
+				depth++
+			case routine && word == "END":
+				// END IF, END LOOP, ... close blocks that are not counted; END CASE closes a CASE
+				next, skip := nextWord(sql[end:])
+				if next == "CASE" || blockEnds[next] {
+					line += strings.Count(sql[end:end+skip], "\n")
+					i = end + skip - 1
+				}
+				if depth > 0 && !blockEnds[next] {
+					depth--
+				}
+			}
+		case c == ';' && depth == 0:
+			flush(i)
+			start, startLine = i+1, line
+			words, create, routine = 0, false, false
+		}
+	}
+	flush(len(sql))
+	return out, exact
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// nextWord returns the upper cased word following the blanks starting s, and
+// the length of s up to the end of that word.
+func nextWord(s string) (word string, n int) {
+	start := len(s) - len(strings.TrimLeft(s, " \t\r\n"))
+	end := start
+	for end < len(s) && isWordByte(s[end]) {
+		end++
+	}
+	return strings.ToUpper(s[start:end]), end
+}
+
+// leadingTrivia returns the length of the whitespace and comments starting s.
+func leadingTrivia(s string) int {
+	i := 0
+	for i < len(s) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(s[i])):
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+var (
+	reLineComment  = regexp.MustCompile(`--[^\n]*`)
+	reBlockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	reSpaces       = regexp.MustCompile(`\s+`)
+	reIdentQuotes  = regexp.MustCompile("[\"`]")
+)
+
+// normalizeStatement strips comments and identifier quotes, collapses whitespace and upper cases.
+func normalizeStatement(sql string) string {
+	sql = reBlockComment.ReplaceAllString(sql, " ")
+	sql = reLineComment.ReplaceAllString(sql, " ")
+	sql = reIdentQuotes.ReplaceAllString(sql, "")
+	return strings.ToUpper(strings.TrimSpace(reSpaces.ReplaceAllString(sql, " ")))
+}
+
+// lintTargets collects the SQL of every changeset of the changelog.
+func lintTargets(src *changelogSource, d dialect) ([]lintTarget, error) {
+	var targets []lintTarget
+	for i, m := range src.doc.Items {
+		t := lintTarget{ID: m.ID, Transactional: m.Transactional == nil || *m.Transactional}
+		if m.Lint != nil {
+			t.Ignore = splitList(m.Lint.Ignore)
+		}
+
+		switch m.Kind {
+		case "sql":
+			if m.IncludeUp == nil {
+				continue
+			}
+			sql, err := readSQL(src.baseDir, m.IncludeUp.File, m.IncludeUp.Rel, src.doc.props)
+			if err != nil {
+				return nil, fmt.Errorf("%s up: %w", m.ID, err)
+			}
+			t.File = includePath(src.baseDir, m.IncludeUp.File, m.IncludeUp.Rel)
+			t.Statements = splitStatements(sql)
+		case "change":
+			up, _, _, err := buildChanges(d, m)
+			if err != nil {
+				return nil, err
+			}
+			t.File = src.path
+			for _, stmt := range up {
+				t.Statements = append(t.Statements, statement{SQL: stmt, Line: src.line(i)})
+			}
+		default:
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// lintTargetRules applies the lintRules of driver to every statement of t.
+func lintTargetRules(t lintTarget, driver string) []lintFinding {
+	var findings []lintFinding
+	for _, stmt := range t.Statements {
+		normalized := normalizeStatement(stmt.SQL)
+		for _, r := range lintRules {
+			if len(r.Drivers) > 0 && !contains(r.Drivers, driver) {
+				continue
+			}
+			if contains(t.Ignore, r.ID) || !r.Check(t, normalized) {
+				continue
+			}
+			findings = append(findings, lintFinding{
+				Rule:     r.ID,
+				Severity: r.Severity,
+				File:     t.File,
+				Line:     stmt.Line,
+				ID:       t.ID,
+				Message:  r.Description,
+			})
+		}
+	}
+	return findings
+}
+
+// lintChangelog applies lintRules to every changeset of the changelog at path.
+// ids lists the linted changesets in changelog order.
+func lintChangelog(path string) (findings []lintFinding, ids []string, err error) {
+	src, issues, err := readChangelogSource(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if src == nil {
+		if len(issues) == 0 {
+			return nil, nil, fmt.Errorf("%s: cannot decode the changelog", path)
+		}
+		return nil, nil, fmt.Errorf("%s:%d: %s", path, issues[0].Line, issues[0].Message)
+	}
+
+	d, err := newDialect(Driver, src.doc.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err := lintTargets(src, d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, t := range targets {
+		ids = append(ids, t.ID)
+		findings = append(findings, lintTargetRules(t, d.driver)...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, ids, nil
+}
+
+// RunLint lints the SQL of every changeset and exits non-zero when a finding
+// reaches the --fail-on severity.
+func RunLint(cmd *cobra.Command, _ []string) {
+	if err := checkReportFormat(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	failRank, ok := severityRank[LintFailOn]
+	if !ok {
+		log.Fatalf("invalid --fail-on=%s (error, warning, info)", LintFailOn)
+		return
+	}
+
+	path := Folder.JoinPath("migrations.xml")
+	findings, ids, err := lintChangelog(path)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	out := cmd.OutOrStdout()
+	rep := newReport("lint")
+	descriptions := make(map[string]string, len(lintRules))
+	for _, r := range lintRules {
+		descriptions[r.ID] = r.Description
+	}
+
+	failing := 0
+	failed := make(map[string][]string)
+	for _, f := range findings {
+		if ReportFormat == "" || ReportFile != "" {
+			fmt.Fprintln(out, f)
+		}
+		rep.addResult(reportResult{Rule: f.Rule, Severity: f.Severity, Message: f.ID + ": " + f.Message, File: f.File, Line: f.Line},
+			descriptions[f.Rule])
+		if severityRank[f.Severity] >= failRank {
+			failing++
+			failed[f.ID] = append(failed[f.ID], f.String())
+		}
+	}
+	for _, id := range ids {
+		if msgs, ok := failed[id]; ok {
+			rep.addCase(reportCase{Name: id, Status: caseFailed, Message: strings.Join(msgs, "\n")})
+		} else {
+			rep.addCase(reportCase{Name: id, Status: casePassed})
+		}
+	}
+	if err := rep.write(out); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if failing > 0 {
+		log.Fatalf("%s: %d finding(s) at or above %s", path, failing, LintFailOn)
+	}
+	if ReportFormat == "" || ReportFile != "" {
+		fmt.Fprintf(out, "%s: %d finding(s)\n", path, len(findings))
+	}
+}
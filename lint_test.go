@@ -0,0 +1,166 @@
+package baselith
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		want  []statement
+		exact bool
+	}{
+		{
+			name:  "plain statements and comments",
+			sql:   "-- users\nCREATE TABLE a (id int);\n\n/* b; */\nINSERT INTO a VALUES (1);\n",
+			want:  []statement{{SQL: "-- users\nCREATE TABLE a (id int)", Line: 2}, {SQL: "/* b; */\nINSERT INTO a VALUES (1)", Line: 5}},
+			exact: true,
+		},
+		{
+			name: "mysql procedure",
+			sql: "CREATE PROCEDURE p()\nBEGIN\n  DECLARE n INT;\n  IF n > 0 THEN\n    UPDATE a SET x = 1;\n  END IF;\n" +
+				"  CASE n WHEN 1 THEN SELECT 1; END CASE;\nEND;\nSELECT 2;",
+			want: []statement{
+				{SQL: "CREATE PROCEDURE p()\nBEGIN\n  DECLARE n INT;\n  IF n > 0 THEN\n    UPDATE a SET x = 1;\n  END IF;\n" +
+					"  CASE n WHEN 1 THEN SELECT 1; END CASE;\nEND", Line: 1},
+				{SQL: "SELECT 2", Line: 9},
+			},
+			exact: true,
+		},
+		{
+			name: "mysql trigger with definer",
+			sql:  "CREATE DEFINER=`app`@`%` TRIGGER t BEFORE INSERT ON a FOR EACH ROW\nBEGIN\n  SET NEW.x = CASE WHEN NEW.y THEN 1 ELSE 2 END;\nEND;",
+			want: []statement{
+				{SQL: "CREATE DEFINER=`app`@`%` TRIGGER t BEFORE INSERT ON a FOR EACH ROW\nBEGIN\n  SET NEW.x = CASE WHEN NEW.y THEN 1 ELSE 2 END;\nEND", Line: 1},
+			},
+			exact: true,
+		},
+		{
+			name: "postgres BEGIN ATOMIC",
+			sql:  "CREATE OR REPLACE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT 2;\nEND;\nBEGIN;\nCOMMIT;",
+			want: []statement{
+				{SQL: "CREATE OR REPLACE FUNCTION f() RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT 2;\nEND", Line: 1},
+				{SQL: "BEGIN", Line: 6},
+				{SQL: "COMMIT", Line: 7},
+			},
+			exact: true,
+		},
+		{
+			name: "dollar quoted body",
+			sql:  "CREATE FUNCTION f() RETURNS trigger AS $body$\nBEGIN\n  RETURN NEW;\nEND;\n$body$ LANGUAGE plpgsql;\nSELECT $$a;b$$;",
+			want: []statement{
+				{SQL: "CREATE FUNCTION f() RETURNS trigger AS $body$\nBEGIN\n  RETURN NEW;\nEND;\n$body$ LANGUAGE plpgsql", Line: 1},
+				{SQL: "SELECT $$a;b$$", Line: 6},
+			},
+			exact: true,
+		},
+		{
+			name:  "postgres E string",
+			sql:   "INSERT INTO a VALUES (E'it\\'s; x');\nSELECT 1;",
+			want:  []statement{{SQL: "INSERT INTO a VALUES (E'it\\'s; x')", Line: 1}, {SQL: "SELECT 1", Line: 2}},
+			exact: true,
+		},
+		{
+			name:  "doubled quotes",
+			sql:   "INSERT INTO a VALUES ('it''s; x');SELECT 1;",
+			want:  []statement{{SQL: "INSERT INTO a VALUES ('it''s; x')", Line: 1}, {SQL: "SELECT 1", Line: 1}},
+			exact: true,
+		},
+		{
+			name:  "backslash escape is not exact",
+			sql:   "INSERT INTO a VALUES ('it\\'s; x');\nSELECT 1;",
+			want:  []statement{{SQL: "INSERT INTO a VALUES ('it\\'s; x')", Line: 1}, {SQL: "SELECT 1", Line: 2}},
+			exact: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exact := scanStatements(tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanStatements() = %q, want %q", got, tt.want)
+			}
+			if exact != tt.exact {
+				t.Errorf("scanStatements() exact = %t, want %t", exact, tt.exact)
+			}
+		})
+	}
+}
+
+func TestSQLStatements(t *testing.T) {
+	sql := "UPDATE a SET x = 'C:\\';\nUPDATE b SET y = 1;"
+	if got := sqlStatements(sql); !reflect.DeepEqual(got, []string{sql}) {
+		t.Errorf("sqlStatements() = %q, want the whole file", got)
+	}
+	if got := sqlStatements("-- only a comment\n;SELECT 1;"); !reflect.DeepEqual(got, []string{"SELECT 1"}) {
+		t.Errorf("sqlStatements() = %q, want [SELECT 1]", got)
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		driver        string
+		transactional bool
+		sql           string
+		want          []string
+	}{
+		{name: "add column not null without default", driver: "postgres", sql: "ALTER TABLE a ADD COLUMN b int NOT NULL",
+			want: []string{"add-column-not-null-no-default"}},
+		{name: "add column not null with default", driver: "postgres", sql: "ALTER TABLE a ADD COLUMN b int NOT NULL DEFAULT 0"},
+		{name: "add identity column", driver: "postgres", sql: "ALTER TABLE a ADD COLUMN id int GENERATED ALWAYS AS IDENTITY NOT NULL"},
+		{name: "add key clauses", driver: "mysql", sql: "ALTER TABLE a ADD PRIMARY KEY (id), ADD UNIQUE KEY u (b)"},
+		{name: "create index", driver: "postgres", sql: "CREATE INDEX i ON a (b)", want: []string{"create-index-not-concurrent"}},
+		{name: "create index concurrently", driver: "postgres", sql: "CREATE UNIQUE INDEX CONCURRENTLY i ON a (b)"},
+		{name: "create index concurrently in transaction", driver: "postgres", transactional: true,
+			sql: "CREATE INDEX CONCURRENTLY i ON a (b)", want: []string{"concurrently-in-transaction"}},
+		{name: "create index on mysql", driver: "mysql", sql: "CREATE INDEX i ON a (b)"},
+		{name: "mysql ddl in transaction", driver: "mysql", transactional: true, sql: "CREATE TABLE a (id int)",
+			want: []string{"ddl-in-transaction"}},
+		{name: "mysql insert in transaction", driver: "mysql", transactional: true, sql: "INSERT INTO a VALUES (1)"},
+		{name: "drop column", driver: "postgres", sql: "ALTER TABLE a DROP COLUMN b", want: []string{"drop-column"}},
+		{name: "drop column if exists", driver: "postgres", sql: "ALTER TABLE a DROP COLUMN IF EXISTS b", want: []string{"drop-column"}},
+		{name: "mysql bare drop", driver: "mysql", sql: "ALTER TABLE `a` DROP `b`", want: []string{"drop-column"}},
+		{name: "drop column after other clause", driver: "mysql", sql: "ALTER TABLE a DROP INDEX i, DROP c", want: []string{"drop-column"}},
+		{name: "drop constraint", driver: "postgres", sql: "ALTER TABLE a DROP CONSTRAINT a_fk"},
+		{name: "drop foreign key", driver: "mysql", sql: "ALTER TABLE a DROP FOREIGN KEY a_fk"},
+		{name: "drop index", driver: "mysql", sql: "ALTER TABLE a DROP INDEX i"},
+		{name: "drop primary key", driver: "mysql", sql: "ALTER TABLE a DROP PRIMARY KEY"},
+		{name: "drop check", driver: "mysql", sql: "ALTER TABLE a DROP CHECK c"},
+		{name: "drop default", driver: "postgres", sql: "ALTER TABLE a ALTER COLUMN b DROP DEFAULT"},
+		{name: "drop not null", driver: "postgres", sql: "ALTER TABLE a ALTER COLUMN b DROP NOT NULL"},
+		{name: "drop table", driver: "postgres", sql: "DROP TABLE IF EXISTS a", want: []string{"drop-table"}},
+		{name: "rename column", driver: "postgres", sql: "ALTER TABLE a RENAME COLUMN b TO c", want: []string{"rename"}},
+		{name: "rename table", driver: "mysql", sql: "RENAME TABLE a TO b", want: []string{"rename"}},
+		{name: "alter column type", driver: "postgres", sql: "ALTER TABLE a ALTER COLUMN b TYPE bigint", want: []string{"alter-column-type"}},
+		{name: "modify column", driver: "mysql", sql: "ALTER TABLE a MODIFY b bigint", want: []string{"alter-column-type"}},
+		{name: "validated foreign key", driver: "postgres",
+			sql:  "ALTER TABLE a ADD CONSTRAINT a_fk FOREIGN KEY (b) REFERENCES c (id)",
+			want: []string{"add-foreign-key-validated"}},
+		{name: "foreign key not valid", driver: "postgres",
+			sql: "ALTER TABLE a ADD CONSTRAINT a_fk FOREIGN KEY (b) REFERENCES c (id) NOT VALID"},
+		{name: "comments and quotes", driver: "postgres", sql: "-- drop it\nALTER TABLE \"a\" /* old */ DROP \"b\"", want: []string{"drop-column"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := lintTarget{ID: "c1", Transactional: tt.transactional, Statements: []statement{{SQL: tt.sql, Line: 1}}}
+			var got []string
+			for _, f := range lintTargetRules(target, tt.driver) {
+				got = append(got, f.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintIgnore(t *testing.T) {
+	target := lintTarget{ID: "c1", Ignore: []string{"drop-column"},
+		Statements: []statement{{SQL: "ALTER TABLE a DROP COLUMN b", Line: 3}, {SQL: "DROP TABLE c", Line: 4}}}
+	got := lintTargetRules(target, "postgres")
+	if len(got) != 1 || got[0].Rule != "drop-table" || got[0].Line != 4 {
+		t.Errorf("findings = %v, want drop-table on line 4", got)
+	}
+}
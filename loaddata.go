@@ -0,0 +1,310 @@
+package baselith
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const defaultLoadBatchSize = 500
+
+// loadData seeds a table from a CSV file. The first CSV record is the header.
+type loadData struct {
+	d         dialect
+	path      string
+	table     string
+	pk        []string
+	upsert    bool
+	batchSize int
+	separator rune
+	types     map[string]string
+}
+
+// newLoadData validates the <loadData> element of m and checks that the CSV header is usable.
+func newLoadData(d dialect, m xmlChangelog, baseDir string) (*loadData, error) {
+	ld := m.LoadData
+	if ld == nil {
+		return nil, fmt.Errorf("%s: kind=loadData requires a <loadData> element", m.ID)
+	}
+	if ld.File == "" {
+		return nil, fmt.Errorf("%s: <loadData> missing file", m.ID)
+	}
+
+	table := ld.Table
+	if table == "" && m.Table != nil {
+		table = m.Table.Name
+	}
+	if table == "" {
+		return nil, fmt.Errorf("%s: <loadData> missing table (or <table name>)", m.ID)
+	}
+
+	l := &loadData{
+		d:         d,
+		path:      includePath(baseDir, ld.File, ld.Rel),
+		table:     table,
+		pk:        splitList(ld.PrimaryKey),
+		batchSize: ld.BatchSize,
+		separator: ',',
+		types:     make(map[string]string),
+	}
+	if l.batchSize <= 0 {
+		l.batchSize = defaultLoadBatchSize
+	}
+	if ld.Separator != "" {
+		l.separator = []rune(ld.Separator)[0]
+	}
+
+	switch ld.Mode {
+	case "", "insert":
+	case "upsert":
+		if len(l.pk) == 0 {
+			return nil, fmt.Errorf("%s: <loadData mode=\"upsert\"> requires primaryKey", m.ID)
+		}
+		l.upsert = true
+	default:
+		return nil, fmt.Errorf("%s: <loadData> unsupported mode=%s", m.ID, ld.Mode)
+	}
+
+	for _, c := range ld.Columns {
+		switch c.Type {
+		case "", "string", "numeric", "boolean", "date", "computed", "skip":
+			l.types[c.Name] = c.Type
+		default:
+			return nil, fmt.Errorf("%s: <loadData> column %s: unsupported type=%s", m.ID, c.Name, c.Type)
+		}
+	}
+
+	header, err := l.header()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.ID, err)
+	}
+	for _, k := range l.pk {
+		if indexOf(header, k) < 0 {
+			return nil, fmt.Errorf("%s: primaryKey column %s not found in %s", m.ID, k, l.path)
+		}
+		if l.types[k] == "skip" || l.types[k] == "computed" {
+			return nil, fmt.Errorf("%s: primaryKey column %s cannot be of type %s", m.ID, k, l.types[k])
+		}
+	}
+	return l, nil
+}
+
+func (l *loadData) open() (*os.File, *csv.Reader, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := csv.NewReader(f)
+	r.Comma = l.separator
+	r.TrimLeadingSpace = true
+	return f, r, nil
+}
+
+func (l *loadData) header() ([]string, error) {
+	f, r, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read header: %w", l.path, err)
+	}
+	return header, nil
+}
+
+// eachBatch streams the CSV, calling fn with the loaded columns and up to batchSize converted rows.
+func (l *loadData) eachBatch(fn func(cols []string, rows [][]any) error) error {
+	f, r, err := l.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: failed to read header: %w", l.path, err)
+	}
+
+	var cols []string
+	var idx []int
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		if l.types[h] == "skip" {
+			continue
+		}
+		cols = append(cols, h)
+		idx = append(idx, i)
+	}
+
+	batch := make([][]any, 0, l.batchSize)
+	line := 1
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", l.path, line, err)
+		}
+
+		row := make([]any, len(cols))
+		for j, i := range idx {
+			v, err := l.convert(cols[j], rec[i])
+			if err != nil {
+				return fmt.Errorf("%s:%d: column %s: %w", l.path, line, cols[j], err)
+			}
+			row[j] = v
+		}
+
+		batch = append(batch, row)
+		if len(batch) == l.batchSize {
+			if err := fn(cols, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return fn(cols, batch)
+	}
+	return nil
+}
+
+// convert maps a CSV value to a query argument according to the column type.
+// Empty values of non-string columns and the literal NULL are loaded as NULL.
+func (l *loadData) convert(col, v string) (any, error) {
+	typ := l.types[col]
+	if v == "NULL" || (v == "" && typ != "" && typ != "string") {
+		return nil, nil
+	}
+	switch typ {
+	case "numeric":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+		return strconv.ParseFloat(v, 64)
+	case "boolean":
+		return strconv.ParseBool(v)
+	case "date":
+		return parseDate(v)
+	case "computed":
+		return gorm.Expr(v), nil
+	default:
+		return v, nil
+	}
+}
+
+// load inserts (or upserts) every CSV row.
+func (l *loadData) load(tx *gorm.DB) error {
+	return l.eachBatch(func(cols []string, rows [][]any) error {
+		placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+		values := make([]string, len(rows))
+		args := make([]any, 0, len(rows)*len(cols))
+		for i, row := range rows {
+			values[i] = placeholders
+			args = append(args, row...)
+		}
+
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+			l.d.table(l.table), l.d.quoteList(strings.Join(cols, ",")), strings.Join(values, ", "))
+		if l.upsert {
+			stmt += " " + l.upsertClause(cols)
+		}
+		return tx.Exec(stmt, args...).Error
+	})
+}
+
+// upsertClause renders the native upsert suffix for the dialect.
+func (l *loadData) upsertClause(cols []string) string {
+	var sets []string
+	for _, c := range cols {
+		if indexOf(l.pk, c) >= 0 {
+			continue
+		}
+		if l.d.isPostgres() {
+			sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", l.d.quote(c), l.d.quote(c)))
+		} else {
+			sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", l.d.quote(c), l.d.quote(c)))
+		}
+	}
+
+	if l.d.isPostgres() {
+		conflict := "ON CONFLICT (" + l.d.quoteList(strings.Join(l.pk, ",")) + ")"
+		if len(sets) == 0 {
+			return conflict + " DO NOTHING"
+		}
+		return conflict + " DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	if len(sets) == 0 {
+		// every column is part of the key: keep the existing row
+		q := l.d.quote(l.pk[0])
+		return "ON DUPLICATE KEY UPDATE " + q + " = " + q
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// unload deletes the rows whose primary keys appear in the CSV.
+func (l *loadData) unload(tx *gorm.DB) error {
+	if len(l.pk) == 0 {
+		return fmt.Errorf("rollback of %s requires primaryKey", l.path)
+	}
+	return l.eachBatch(func(cols []string, rows [][]any) error {
+		pos := make([]int, len(l.pk))
+		for i, k := range l.pk {
+			pos[i] = indexOf(cols, k)
+		}
+
+		keyTuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(l.pk)), ", ") + ")"
+		tuples := make([]string, len(rows))
+		args := make([]any, 0, len(rows)*len(l.pk))
+		for i, row := range rows {
+			tuples[i] = keyTuple
+			for _, p := range pos {
+				args = append(args, row[p])
+			}
+		}
+
+		stmt := fmt.Sprintf("DELETE FROM %s WHERE (%s) IN (%s)",
+			l.d.table(l.table), l.d.quoteList(strings.Join(l.pk, ",")), strings.Join(tuples, ", "))
+		return tx.Exec(stmt, args...).Error
+	})
+}
+
+func indexOf(list []string, v string) int {
+	for i, s := range list {
+		if strings.TrimSpace(s) == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseDate checks an ISO-8601 date or date and time. Dates and local times are
+// loaded as normalized text, so that no time zone conversion applies; times
+// with an offset are loaded as a time.Time.
+func parseDate(v string) (any, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t.Format(time.DateOnly), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Format("2006-01-02 15:04:05.999999999"), nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q, want an ISO-8601 date (2006-01-02) or date and time (2006-01-02T15:04:05, optionally with an offset)", v)
+}
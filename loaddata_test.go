@@ -0,0 +1,43 @@
+package baselith
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConvertDate(t *testing.T) {
+	l := &loadData{types: map[string]string{"born": "date"}}
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"2024-02-29", "2024-02-29"},
+		{"2024-02-29T13:04:05", "2024-02-29 13:04:05"},
+		{"2024-02-29 13:04:05.250", "2024-02-29 13:04:05.25"},
+		{"2024-02-29T13:04:05+02:00", time.Date(2024, 2, 29, 11, 4, 5, 0, time.UTC)},
+		{"", nil},
+		{"NULL", nil},
+	}
+	for _, tt := range tests {
+		got, err := l.convert("born", tt.in)
+		if err != nil {
+			t.Errorf("convert(%q): %v", tt.in, err)
+			continue
+		}
+		if want, ok := tt.want.(time.Time); ok {
+			if gt, ok := got.(time.Time); !ok || !gt.Equal(want) {
+				t.Errorf("convert(%q) = %v, want %v", tt.in, got, want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("convert(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"2024-02-30", "29/02/2024", "yesterday"} {
+		if _, err := l.convert("born", in); err == nil {
+			t.Errorf("convert(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package baselith
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+const (
+	lockPollInterval = 500 * time.Millisecond
+	lockHeartbeat    = 10 * time.Second
+	// lockStaleAfter is how long a table lock may go without a heartbeat
+	// before it is considered abandoned.
+	lockStaleAfter = time.Minute
+)
+
+// errLockTimeout is returned when the migration lock is not acquired within --lock-timeout.
+var errLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// lockHolder describes who holds the migration lock.
+type lockHolder struct {
+	Holder     string
+	AcquiredAt *time.Time
+	Heartbeat  *time.Time
+	State      string
+	Stale      bool
+}
+
+func (h *lockHolder) String() string {
+	s := h.Holder
+	if h.AcquiredAt != nil {
+		s += " since " + h.AcquiredAt.Format(time.RFC3339)
+	}
+	if h.State != "" {
+		s += " (" + h.State + ")"
+	}
+	if h.Stale {
+		s += " [stale]"
+	}
+	return s
+}
+
+// migrationLock serializes migration runs on a schema across processes.
+type migrationLock interface {
+	acquire(ctx context.Context, timeout time.Duration) error
+	release() error
+	// status returns the current holder, or nil when the lock is free.
+	status(ctx context.Context) (*lockHolder, error)
+	// forceRelease frees a lock held by another process.
+	forceRelease(ctx context.Context) error
+}
+
+// newMigrationLock returns the lock of --lock-mode for the driver.
+func newMigrationLock(db *gorm.DB, driver, schema string) (migrationLock, error) {
+	d, err := newDialect(driver, schema)
+	if err != nil {
+		return nil, err
+	}
+	key := "baselith:" + schema
+	switch LockMode {
+	case "advisory":
+		if d.isPostgres() {
+			return &pgAdvisoryLock{db: db, key: key}, nil
+		}
+		// lock names are limited to 64 characters
+		if len(key) > 64 {
+			key = key[:64]
+		}
+		return &mysqlAdvisoryLock{db: db, key: key}, nil
+	case "table":
+		return &tableLock{db: db, d: d, table: d.table("schema_migrations_lock")}, nil
+	default:
+		return nil, fmt.Errorf("unsupported lock mode: %s (advisory, table)", LockMode)
+	}
+}
+
+// pollLock calls try until it reports the lock as acquired. A zero timeout waits forever.
+func pollLock(ctx context.Context, timeout time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := try()
+		if err != nil || ok {
+			return err
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return errLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// lockTimeoutError adds the current holder to errLockTimeout.
+func lockTimeoutError(ctx context.Context, l migrationLock, timeout time.Duration) error {
+	if h, err := l.status(ctx); err == nil && h != nil {
+		return fmt.Errorf("%w after %s: held by %s", errLockTimeout, timeout, h)
+	}
+	return fmt.Errorf("%w after %s", errLockTimeout, timeout)
+}
+
+// pgAdvisoryLock is a session level advisory lock held on a dedicated
+// connection, so that lock and unlock run in the same session.
+type pgAdvisoryLock struct {
+	db   *gorm.DB
+	key  string
+	conn *sql.Conn
+}
+
+func (l *pgAdvisoryLock) acquire(ctx context.Context, timeout time.Duration) error {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return err
+	}
+	if l.conn, err = sqlDB.Conn(ctx); err != nil {
+		return err
+	}
+	err = pollLock(ctx, timeout, func() (bool, error) {
+		var ok bool
+		err := l.conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, l.key).Scan(&ok)
+		return ok, err
+	})
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		if errors.Is(err, errLockTimeout) {
+			return lockTimeoutError(ctx, l, timeout)
+		}
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+	return nil
+}
+
+func (l *pgAdvisoryLock) release() error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() { l.conn.Close(); l.conn = nil }()
+	var ok bool
+	if err := l.conn.QueryRowContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, l.key).Scan(&ok); err != nil {
+		return fmt.Errorf("failed to release the migration lock: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("failed to release the migration lock: not held by this session")
+	}
+	return nil
+}
+
+const sqlPostgresLockHolder = `SELECT a.pid, COALESCE(host(a.client_addr), 'local') AS client,
+       a.application_name, a.backend_start, a.state, a.state_change
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+  AND l.objid::bigint = (hashtext(?)::bigint & 4294967295)`
+
+type pgLockHolder struct {
+	Pid             int
+	Client          string
+	ApplicationName string
+	BackendStart    time.Time
+	State           string
+	StateChange     *time.Time
+}
+
+func (l *pgAdvisoryLock) holder(ctx context.Context) (*pgLockHolder, error) {
+	var rows []pgLockHolder
+	if err := l.db.WithContext(ctx).Raw(sqlPostgresLockHolder, l.key).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+func (l *pgAdvisoryLock) status(ctx context.Context) (*lockHolder, error) {
+	h, err := l.holder(ctx)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	state := h.State
+	if h.StateChange != nil {
+		state += " since " + h.StateChange.Format(time.RFC3339)
+	}
+	return &lockHolder{
+		Holder:     fmt.Sprintf("backend pid %d (%s, %s)", h.Pid, h.Client, h.ApplicationName),
+		AcquiredAt: &h.BackendStart,
+		State:      state,
+	}, nil
+}
+
+// forceRelease terminates the backend holding the lock: advisory locks can
+// only be released by their own session.
+func (l *pgAdvisoryLock) forceRelease(ctx context.Context) error {
+	h, err := l.holder(ctx)
+	if err != nil || h == nil {
+		return err
+	}
+	var ok bool
+	if err := l.db.WithContext(ctx).Raw(`SELECT pg_terminate_backend(?)`, h.Pid).Scan(&ok).Error; err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("failed to terminate backend %d", h.Pid)
+	}
+	return nil
+}
+
+// mysqlAdvisoryLock is a GET_LOCK named lock held on a dedicated connection.
+type mysqlAdvisoryLock struct {
+	db   *gorm.DB
+	key  string
+	conn *sql.Conn
+}
+
+func (l *mysqlAdvisoryLock) acquire(ctx context.Context, timeout time.Duration) error {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return err
+	}
+	if l.conn, err = sqlDB.Conn(ctx); err != nil {
+		return err
+	}
+	err = pollLock(ctx, timeout, func() (bool, error) {
+		// 1 when acquired, 0 when held elsewhere, NULL on error
+		var got sql.NullInt64
+		if err := l.conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, l.key).Scan(&got); err != nil {
+			return false, err
+		}
+		if !got.Valid {
+			return false, fmt.Errorf("GET_LOCK(%q) failed", l.key)
+		}
+		return got.Int64 == 1, nil
+	})
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		if errors.Is(err, errLockTimeout) {
+			return lockTimeoutError(ctx, l, timeout)
+		}
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+	return nil
+}
+
+func (l *mysqlAdvisoryLock) release() error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() { l.conn.Close(); l.conn = nil }()
+	var released sql.NullInt64
+	if err := l.conn.QueryRowContext(context.Background(), `SELECT RELEASE_LOCK(?)`, l.key).Scan(&released); err != nil {
+		return fmt.Errorf("failed to release the migration lock: %w", err)
+	}
+	if !released.Valid || released.Int64 != 1 {
+		return fmt.Errorf("failed to release the migration lock: not held by this session")
+	}
+	return nil
+}
+
+type mysqlLockHolder struct {
+	ID    int64
+	User  string
+	Host  string
+	Time  int64
+	State string
+}
+
+func (l *mysqlAdvisoryLock) holder(ctx context.Context) (*mysqlLockHolder, error) {
+	var id sql.NullInt64
+	if err := l.db.WithContext(ctx).Raw(`SELECT IS_USED_LOCK(?)`, l.key).Row().Scan(&id); err != nil {
+		return nil, err
+	}
+	if !id.Valid {
+		return nil, nil
+	}
+	h := &mysqlLockHolder{ID: id.Int64}
+	err := l.db.WithContext(ctx).Raw(`SELECT USER AS user, HOST AS host, TIME AS time, COALESCE(STATE, '') AS state
+FROM information_schema.PROCESSLIST WHERE ID = ?`, id.Int64).Scan(h).Error
+	return h, err
+}
+
+func (l *mysqlAdvisoryLock) status(ctx context.Context) (*lockHolder, error) {
+	h, err := l.holder(ctx)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	return &lockHolder{
+		Holder: fmt.Sprintf("connection %d (%s@%s)", h.ID, h.User, h.Host),
+		State:  strings.TrimSpace(fmt.Sprintf("%s, %ds in current state", h.State, h.Time)),
+	}, nil
+}
+
+// forceRelease kills the connection holding the lock.
+func (l *mysqlAdvisoryLock) forceRelease(ctx context.Context) error {
+	h, err := l.holder(ctx)
+	if err != nil || h == nil {
+		return err
+	}
+	return l.db.WithContext(ctx).Exec(fmt.Sprintf("KILL %d", h.ID)).Error
+}
+
+// tableLock is a row in schema_migrations_lock, for databases or poolers
+// where session level advisory locks are not available. The holder refreshes
+// its heartbeat; a lock without heartbeat for lockStaleAfter is taken over.
+type tableLock struct {
+	db    *gorm.DB
+	d     dialect
+	table string
+	token string
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func (l *tableLock) ensureTable(ctx context.Context) error {
+	ts, now := "timestamptz", "now()"
+	if !l.d.isPostgres() {
+		ts, now = "timestamp(6)", "CURRENT_TIMESTAMP(6)"
+	}
+	return l.db.WithContext(ctx).Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id          integer PRIMARY KEY,
+	token       varchar(64) NOT NULL,
+	holder_host varchar(255) NOT NULL,
+	holder_pid  integer NOT NULL,
+	acquired_at %s NOT NULL DEFAULT %s,
+	heartbeat   %s NOT NULL DEFAULT %s
+)`, l.table, ts, now, ts, now)).Error
+}
+
+// staleCondition matches a row whose heartbeat is older than lockStaleAfter.
+func (l *tableLock) staleCondition() string {
+	secs := int(lockStaleAfter / time.Second)
+	if l.d.isPostgres() {
+		return fmt.Sprintf("heartbeat < now() - interval '%d seconds'", secs)
+	}
+	return fmt.Sprintf("heartbeat < CURRENT_TIMESTAMP(6) - INTERVAL %d SECOND", secs)
+}
+
+func (l *tableLock) acquire(ctx context.Context, timeout time.Duration) error {
+	if err := l.ensureTable(ctx); err != nil {
+		return fmt.Errorf("failed to create the migration lock table: %w", err)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	l.token = hex.EncodeToString(b)
+	host, _ := os.Hostname()
+
+	err := pollLock(ctx, timeout, func() (bool, error) {
+		insert := l.db.WithContext(ctx).Exec(fmt.Sprintf(`INSERT INTO %s (id, token, holder_host, holder_pid) VALUES (1, ?, ?, ?)`, l.table),
+			l.token, host, os.Getpid())
+		if insert.Error == nil {
+			return true, nil
+		}
+		if !isUniqueViolation(insert.Error) {
+			return false, insert.Error // not a conflict with another holder
+		}
+		h, err := l.status(ctx)
+		if err != nil {
+			return false, err
+		}
+		if h == nil {
+			return false, nil // released in the meantime, try again
+		}
+		if h.Stale {
+			log.Printf("Taking over stale migration lock held by %s", h)
+			if err := l.db.WithContext(ctx).Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = 1 AND %s`, l.table, l.staleCondition())).Error; err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		if errors.Is(err, errLockTimeout) {
+			return lockTimeoutError(ctx, l, timeout)
+		}
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+
+	l.stop, l.done = make(chan struct{}), make(chan struct{})
+	go l.heartbeat()
+	return nil
+}
+
+func (l *tableLock) heartbeat() {
+	defer close(l.done)
+	now := "now()"
+	if !l.d.isPostgres() {
+		now = "CURRENT_TIMESTAMP(6)"
+	}
+	ticker := time.NewTicker(lockHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.db.Exec(fmt.Sprintf(`UPDATE %s SET heartbeat = %s WHERE id = 1 AND token = ?`, l.table, now), l.token).Error; err != nil {
+				log.Printf("Failed to refresh the migration lock heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+func (l *tableLock) release() error {
+	if l.stop == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+	res := l.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = 1 AND token = ?`, l.table), l.token)
+	if res.Error != nil {
+		return fmt.Errorf("failed to release the migration lock: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("failed to release the migration lock: it was taken over as stale")
+	}
+	return nil
+}
+
+type lockRow struct {
+	HolderHost string
+	HolderPid  int
+	AcquiredAt time.Time
+	Heartbeat  time.Time
+	Stale      bool
+}
+
+func (l *tableLock) status(ctx context.Context) (*lockHolder, error) {
+	var rows []lockRow
+	err := l.db.WithContext(ctx).Raw(fmt.Sprintf(`SELECT holder_host, holder_pid, acquired_at, heartbeat, %s AS stale
+FROM %s WHERE id = 1`, l.staleCondition(), l.table)).Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	r := rows[0]
+	return &lockHolder{
+		Holder:     fmt.Sprintf("%s pid %d", r.HolderHost, r.HolderPid),
+		AcquiredAt: &r.AcquiredAt,
+		Heartbeat:  &r.Heartbeat,
+		State:      "last heartbeat " + r.Heartbeat.Format(time.RFC3339),
+		Stale:      r.Stale,
+	}, nil
+}
+
+func (l *tableLock) forceRelease(ctx context.Context) error {
+	return l.db.WithContext(ctx).Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = 1`, l.table)).Error
+}
+
+// openLock connects to the database of the changelog and returns its migration lock.
+func openLock() (*gorm.DB, migrationLock, error) {
+	if err := loadConnectionConfig(); err != nil {
+		return nil, nil, err
+	}
+	doc, _, err := parseXML(Folder.JoinPath("migrations.xml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	Schema = doc.Schema
+
+	db, config, err := openDB(doc.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	lock, err := newMigrationLock(db, config.Driver, doc.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tl, ok := lock.(*tableLock); ok {
+		if err := tl.ensureTable(context.Background()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return db, lock, nil
+}
+
+// RunLockStatus prints the holder of the migration lock.
+func RunLockStatus(cmd *cobra.Command, _ []string) {
+	db, lock, err := openLock()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	h, err := lock.status(context.Background())
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "mode: %s\n", LockMode)
+	if h == nil {
+		fmt.Fprintln(out, "lock: free")
+		return
+	}
+	fmt.Fprintf(out, "lock: held by %s\n", h)
+}
+
+// RunLockRelease frees the migration lock of another process. Without --force
+// only stale table locks are released.
+func RunLockRelease(cmd *cobra.Command, _ []string) {
+	db, lock, err := openLock()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	ctx := context.Background()
+	h, err := lock.status(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	out := cmd.OutOrStdout()
+	if h == nil {
+		fmt.Fprintln(out, "lock: free")
+		return
+	}
+	if !LockForce && !h.Stale {
+		log.Fatalf("lock held by %s; use --force to release it", h)
+		return
+	}
+	if err := lock.forceRelease(ctx); err != nil {
+		log.Fatal(err)
+		return
+	}
+	fmt.Fprintf(out, "Released lock held by %s\n", h)
+}
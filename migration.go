@@ -1,8 +1,11 @@
 package baselith
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/go-gormigrate/gormigrate/v2"
@@ -36,24 +39,17 @@ func doAction(m *gormigrate.Gormigrate, sub, toID string) error {
 }
 
 func Run(cmd *cobra.Command, _ []string) {
-	fmt.Fprintln(cmd.OutOrStdout(), "Welcome to Baselith! Use --help for more information.")
-	switch {
-	case ConfigYaml && ConfigPath == "":
-		yamlCfg, err := ReadConfigYAML()
-		if err != nil {
-			log.Fatal("Failed to read YAML config:", err)
-			return
-		}
-		Driver = yamlCfg.Driver
-		Host = yamlCfg.Host
-		Port = yamlCfg.Port
-		Dbname = yamlCfg.Dbname
-		User = yamlCfg.User
-		Password = yamlCfg.Password
-		Schema = yamlCfg.Schema
+	if err := checkReportFormat(); err != nil {
+		log.Fatal(err)
+		return
 	}
-	if Driver == "" || Host == "" || Port == 0 || Dbname == "" || User == "" {
-		log.Fatal("Database connection parameters are required when not using a config file.")
+	// a report written to stdout must be the only output there
+	if ReportFormat == "" || ReportFile != "" {
+		fmt.Fprintln(cmd.OutOrStdout(), "Welcome to Baselith! Use --help for more information.")
+	}
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
 	}
 
 	doc, baseDir, err := loadMigrationsXML(Folder.JoinPath("migrations.xml"))
@@ -64,28 +60,9 @@ func Run(cmd *cobra.Command, _ []string) {
 	Schema = doc.Schema
 	log.Printf("Base directory: %s\n", baseDir)
 
-	config, err := persistence.NewDBConfigBuilder().Driver(Driver).Host(Host).Port(Port).Database(Dbname).
-		Username(User).
-		Password(Password).
-		MaxIdleConns(10).
-		MaxOpenConns(100).
-		Schema(doc.Schema).
-		ConnMaxLifetime(time.Hour).Build()
-	if err != nil {
-		log.Fatal("Failed to build config:", err)
-		return
-	}
-
-	factory := persistence.NewConnectorFactory()
-	connect, err := factory.CreateConnector(config)
+	db, config, err := openDB(doc.Schema)
 	if err != nil {
-		log.Fatal("Failed to create connector:", err)
-		return
-	}
-
-	db, err := connect.Connect()
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal(err)
 		return
 	}
 
@@ -103,7 +80,12 @@ func Run(cmd *cobra.Command, _ []string) {
 	}
 
 	// load migrations from XML
-	txMigs, notxMigs, metasTx, metasNoTx, err := readMigrationsXML(doc, baseDir)
+	txMigs, notxMigs, metasTx, metasNoTx, opts, err := readMigrationsXML(doc, baseDir)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	reps, err := readRepeatablesXML(doc, baseDir)
 	if err != nil {
 		log.Fatal(err)
 		return
@@ -112,22 +94,206 @@ func Run(cmd *cobra.Command, _ []string) {
 	// command dispatcher
 	switch Sub {
 	case "status":
-		if err := cmdStatus(db, append(txMigs, notxMigs...)); err != nil {
+		if err := cmdStatus(db, append(txMigs, notxMigs...), reps); err != nil {
 			log.Fatal(err)
 		}
 	case "history":
 		if err := cmdHistory(db); err != nil {
 			log.Fatal(err)
 		}
+	case "verify":
+		if err := cmdVerify(db, append(txMigs, notxMigs...), opts, reps); err != nil {
+			log.Fatal(err)
+		}
 	case "up", "down", "to", "redo":
-		if err := runMutations(db, Sub, ToID, txMigs, notxMigs, metasTx, metasNoTx, Schema); err != nil {
+		if Sub != "redo" {
+			txMigs, metasTx = selectLabels(txMigs, metasTx)
+			notxMigs, metasNoTx = selectLabels(notxMigs, metasNoTx)
+			reps = selectRepeatableLabels(reps)
+		}
+		rep := newReport(Sub)
+		var ids []string
+		for _, gm := range append(txMigs, notxMigs...) {
+			ids = append(ids, gm.ID)
+		}
+		for _, r := range reps {
+			ids = append(ids, r.ID)
+		}
+
+		in, err := watchInterrupts(db, config.Driver, Schema)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		err = runMutations(in, db, Sub, ToID, rep.wrap(txMigs), rep.wrap(notxMigs), metasTx, metasNoTx, opts, reps, Schema, rep)
+		in.stop()
+		interrupted := errors.Is(err, errInterrupted)
+		var timeout *timeoutError
+		switch {
+		case errors.As(err, &timeout):
+			rep.addResult(reportResult{Rule: "migration-timeout", Severity: severityError, Message: err.Error(),
+				File: Folder.JoinPath("migrations.xml")}, "a changeset exceeded its statement or lock timeout")
+		case err != nil:
+			rep.addResult(reportResult{Rule: "migration-failed", Severity: severityError, Message: err.Error(),
+				File: Folder.JoinPath("migrations.xml")}, "a changeset failed to apply")
+		}
+		rep.skipRemaining(ids, "not executed in this run")
+		if werr := rep.write(cmd.OutOrStdout()); werr != nil {
+			log.Println(werr)
+		}
+		if interrupted {
+			log.Println(err)
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		if Sub == "up" && SnapshotAfterUp {
+			paths, err := writeSchemaSnapshot(db, config.Driver, doc.Schema)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, p := range paths {
+				log.Printf("Schema snapshot written to %s", p)
+			}
+		}
+	}
+}
+
+// selectLabels keeps the changesets of migs whose labels match --label-filter.
+func selectLabels(migs []*gormigrate.Migration, metas map[string]Meta) ([]*gormigrate.Migration, map[string]Meta) {
+	if LabelFilter == "" {
+		return migs, metas
+	}
+	var out []*gormigrate.Migration
+	selected := make(map[string]Meta)
+	for _, gm := range migs {
+		if meta := metas[gm.ID]; labelsSelected(meta.Labels) {
+			out = append(out, gm)
+			selected[gm.ID] = meta
+		}
+	}
+	return out, selected
+}
+
+// selectRepeatableLabels keeps the repeatable changesets whose labels match --label-filter.
+func selectRepeatableLabels(reps []*repeatableMigration) []*repeatableMigration {
+	var out []*repeatableMigration
+	for _, r := range reps {
+		if labelsSelected(r.Meta.Labels) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// loadConnectionConfig checks the connection settings, resolved from flags,
+// environment and the YAML config file before the command ran.
+func loadConnectionConfig() error {
+	if Driver == "" || Host == "" || Port == 0 || Dbname == "" || User == "" {
+		return fmt.Errorf("database connection parameters are required: set --url or driver, host, port, dbname and user with flags, %s* variables or a config file", envPrefix)
+	}
+	return nil
+}
+
+// connectionConfig returns the connection described by the connection flags.
+func connectionConfig(schema string) *DBConfigYAML {
+	return &DBConfigYAML{
+		Driver: Driver, Host: Host, Port: Port, Dbname: Dbname, User: User, Password: Password, Schema: schema,
+		PasswordFile: PasswordFile, PasswordEnv: PasswordEnv, PasswordCommand: PasswordCommand,
+		SSLMode: SSLMode, Options: DBOptions,
+		MaxIdleConns: MaxIdleConns, MaxOpenConns: MaxOpenConns, ConnMaxLifetime: ConnMaxLifetime,
+	}
+}
+
+// openDB connects to the database described by the connection flags.
+func openDB(schema string) (*gorm.DB, *persistence.DBConfig, error) {
+	return openDBFrom(connectionConfig(schema))
+}
+
+// openDBFrom connects to the database described by c.
+func openDBFrom(c *DBConfigYAML) (*gorm.DB, *persistence.DBConfig, error) {
+	if err := c.applyURL(); err != nil {
+		return nil, nil, err
+	}
+	password, err := c.resolvePassword()
+	if err != nil {
+		return nil, nil, err
+	}
+	b := persistence.NewDBConfigBuilder().Driver(c.Driver).Host(c.Host).Port(c.Port).Database(c.Dbname).
+		Username(c.User).
+		Password(password).
+		SSLMode(c.SSLMode).
+		Schema(c.Schema)
+	// zero pool settings (e.g. in a --target-config file) keep the builder defaults
+	if c.MaxIdleConns > 0 {
+		b.MaxIdleConns(c.MaxIdleConns)
+	}
+	if c.MaxOpenConns > 0 {
+		b.MaxOpenConns(c.MaxOpenConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		b.ConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	for k, v := range c.Options {
+		b.AddParam(k, v)
+	}
+	config, err := b.Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build config: %w", err)
+	}
+
+	factory := persistence.NewConnectorFactory()
+	connect, err := factory.CreateConnector(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create connector: %w", err)
+	}
+
+	db, err := waitForDB(connect)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, config, nil
+}
+
+const (
+	waitBackoff    = 500 * time.Millisecond
+	waitMaxBackoff = 5 * time.Second
+	pingTimeout    = 5 * time.Second
+)
+
+// waitForDB connects and pings the database. Until --wait-timeout has passed,
+// failed attempts are retried with a doubling backoff, for databases that are
+// still starting.
+func waitForDB(connect persistence.DBConnector) (*gorm.DB, error) {
+	deadline := time.Now().Add(WaitTimeout)
+	delay := waitBackoff
+	for attempt := 1; ; attempt++ {
+		db, err := connect.Connect()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+			err = connect.Ping(ctx)
+			cancel()
+			if err == nil {
+				return db, nil
+			}
+			connect.Close()
+		}
+		if WaitTimeout <= 0 {
+			return nil, err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("database not ready after %s (%d attempts): %w", WaitTimeout, attempt, err)
+		}
+		log.Printf("Database not ready (attempt %d), retrying in %s: %v", attempt, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, waitMaxBackoff)
 	}
 }
 
 func migrationTable(driver string, db DBInterface) error {
-	var createTableQuery string
+	var createTableQuery, logTableQuery string
 	var alters []string
 
 	switch driver {
@@ -138,6 +304,22 @@ func migrationTable(driver string, db DBInterface) error {
 	author      varchar(128) NOT NULL DEFAULT 'unknown',
 	labels      varchar(255) NOT NULL DEFAULT 'unknown',
 	kind        varchar(32)
+)`, Schema)
+		logTableQuery = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.schema_migrations_log (
+	seq              bigserial PRIMARY KEY,
+	id               varchar(255) NOT NULL,
+	direction        varchar(16) NOT NULL,
+	outcome          varchar(16) NOT NULL,
+	transactional    boolean NOT NULL DEFAULT true,
+	dirty            boolean NOT NULL DEFAULT false,
+	message          text,
+	statement_index  integer,
+	started_at       timestamptz NOT NULL DEFAULT now(),
+	finished_at      timestamptz,
+	duration_ms      bigint,
+	executed_by      varchar(128) NOT NULL DEFAULT 'unknown',
+	executed_host    varchar(255) NOT NULL DEFAULT 'unknown',
+	baselith_version varchar(32) NOT NULL DEFAULT 'unknown'
 )`, Schema)
 		alters = []string{
 			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS applied_at timestamptz NOT NULL DEFAULT now()`, Schema),
@@ -145,6 +327,18 @@ func migrationTable(driver string, db DBInterface) error {
 			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS labels varchar(255) NOT NULL DEFAULT 'unknown'`, Schema),
 			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS kind varchar(32)`, Schema),
 			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS transactional boolean NOT NULL DEFAULT true`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS checksum varchar(64)`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations ADD COLUMN IF NOT EXISTS exec_count integer NOT NULL DEFAULT 1`, Schema),
+			// schema_migrations_log tables created before the execution log columns
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS transactional boolean NOT NULL DEFAULT true`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS dirty boolean NOT NULL DEFAULT false`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS statement_index integer`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS started_at timestamptz NOT NULL DEFAULT now()`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS finished_at timestamptz`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS duration_ms bigint`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS executed_by varchar(128) NOT NULL DEFAULT 'unknown'`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS executed_host varchar(255) NOT NULL DEFAULT 'unknown'`, Schema),
+			fmt.Sprintf(`ALTER TABLE %s.schema_migrations_log ADD COLUMN IF NOT EXISTS baselith_version varchar(32) NOT NULL DEFAULT 'unknown'`, Schema),
 		}
 	case "mysql":
 		createTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
@@ -153,7 +347,25 @@ func migrationTable(driver string, db DBInterface) error {
 	author      varchar(128) NOT NULL DEFAULT 'unknown',
 	labels      varchar(255) NOT NULL DEFAULT 'unknown',
 	kind        varchar(32),
-	transactional boolean NOT NULL DEFAULT true
+	transactional boolean NOT NULL DEFAULT true,
+	checksum    varchar(64),
+	exec_count  integer NOT NULL DEFAULT 1
+)`
+		logTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations_log (
+	seq              bigint AUTO_INCREMENT PRIMARY KEY,
+	id               varchar(255) NOT NULL,
+	direction        varchar(16) NOT NULL,
+	outcome          varchar(16) NOT NULL,
+	transactional    boolean NOT NULL DEFAULT true,
+	dirty            boolean NOT NULL DEFAULT false,
+	message          text,
+	statement_index  integer,
+	started_at       datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	finished_at      datetime(6),
+	duration_ms      bigint,
+	executed_by      varchar(128) NOT NULL DEFAULT 'unknown',
+	executed_host    varchar(255) NOT NULL DEFAULT 'unknown',
+	baselith_version varchar(32) NOT NULL DEFAULT 'unknown'
 )`
 		alters = []string{
 			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS applied_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP`,
@@ -161,6 +373,18 @@ func migrationTable(driver string, db DBInterface) error {
 			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS labels varchar(255) NOT NULL DEFAULT 'unknown'`,
 			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS kind varchar(32)`,
 			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS transactional boolean NOT NULL DEFAULT true`,
+			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum varchar(64)`,
+			`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS exec_count integer NOT NULL DEFAULT 1`,
+			// schema_migrations_log tables created before the execution log columns
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS transactional boolean NOT NULL DEFAULT true`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS dirty boolean NOT NULL DEFAULT false`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS statement_index integer`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS started_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS finished_at datetime(6)`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS duration_ms bigint`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS executed_by varchar(128) NOT NULL DEFAULT 'unknown'`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS executed_host varchar(255) NOT NULL DEFAULT 'unknown'`,
+			`ALTER TABLE schema_migrations_log ADD COLUMN IF NOT EXISTS baselith_version varchar(32) NOT NULL DEFAULT 'unknown'`,
 		}
 	default:
 		return fmt.Errorf("unsupported driver: %s", driver)
@@ -172,6 +396,11 @@ func migrationTable(driver string, db DBInterface) error {
 		return fmt.Errorf("failed to create migration table: %w", err)
 	}
 
+	log.Printf("Creating migration log table with query: %s", logTableQuery)
+	if err := db.Exec(logTableQuery).Error(); err != nil {
+		return fmt.Errorf("failed to create migration log table: %w", err)
+	}
+
 	for _, q := range alters {
 		log.Printf("Altering table with query: %s", q)
 		result = db.Exec(q)
@@ -182,72 +411,100 @@ func migrationTable(driver string, db DBInterface) error {
 	return nil
 }
 
+// runMutations applies sub under the migration lock. Every statement runs with
+// the context of in, and the run stops early once in is interrupted.
 func runMutations(
+	in *interrupter,
 	db *gorm.DB,
 	sub, toID string,
 	txMigs, notxMigs []*gormigrate.Migration,
 	metasTx, metasNoTx map[string]Meta,
+	opts map[string]changesetOptions,
+	reps []*repeatableMigration,
 	schema string,
+	rep *report,
 ) error {
 	dbAdapter := NewDBAdapter(db)
+	ctxDB := db.WithContext(in.ctx)
 
-	// LOCK session for batch transactional
-	// Use database-specific locking mechanism
-	var result DBResult
-	if Driver == "postgres" || Driver == "postgresql" {
-		exec := dbAdapter.Exec(`SELECT pg_advisory_lock( hashtext('gormigrate:xml:tx') )`)
-		if err := exec.Error(); err != nil {
-			return err
-		}
-		result = exec
-		defer dbAdapter.Exec(`SELECT pg_advisory_unlock( hashtext('gormigrate:xml:tx') )`)
-	} else if Driver == "mysql" {
-		exec := dbAdapter.Exec(`SELECT GET_LOCK('gormigrate:xml:tx', 10)`)
-		if err := exec.Error(); err != nil {
-			return err
+	// one lock for the whole run, so that concurrent runs never interleave
+	lock, err := newMigrationLock(db, Driver, schema)
+	if err != nil {
+		return err
+	}
+	if err := lock.acquire(in.wait, LockTimeout); err != nil {
+		return in.err(err)
+	}
+	defer func() {
+		if err := lock.release(); err != nil {
+			log.Println(err)
 		}
-		result = exec
-		defer dbAdapter.Exec(`SELECT RELEASE_LOCK('gormigrate:xml:tx')`)
+	}()
+
+	// a non-transactional changeset that failed part-way must be repaired first
+	x := newExecLog(db, in.d, schema, in)
+	if err := x.checkClean(); err != nil {
+		return err
 	}
 
 	// Runner transactional
-	mtx := gormigrate.New(db, &gormigrate.Options{
+	mtx := gormigrate.New(ctxDB, &gormigrate.Options{
 		TableName:      schema + ".schema_migrations",
 		IDColumnName:   "id",
 		IDColumnSize:   255,
 		UseTransaction: true,
-	}, txMigs)
+	}, x.wrap(in.wrap(txMigs), true))
 
-	if err := doAction(mtx, sub, toID); err != nil {
+	// the transactional batch is retried as a whole; redo is not, as its
+	// rollback is committed before the migrate
+	retry := newRetrier(in)
+	runBatch := func() error {
+		err := doAction(mtx, sub, toID)
+		if err != nil {
+			x.revertBatch()
+			rep.revertBatch(txMigs)
+		}
 		return err
 	}
+	if sub == "redo" {
+		err = runBatch()
+	} else {
+		err = retry.do("transactional batch", runBatch)
+	}
+	if err != nil {
+		return in.err(err)
+	}
 
 	if err := syncMetadata(dbAdapter, schema, metasTx); err != nil {
 		return err
 	}
 
-	// NON-transactional batch use lock session a side for race condition
+	// NON-transactional batch
 	if len(notxMigs) > 0 {
-		result = dbAdapter.Exec(`SELECT pg_advisory_lock( hashtext('gormigrate:xml:notx') )`)
-		if err := result.Error(); err != nil {
-			return err
-		}
-		defer dbAdapter.Exec(`SELECT pg_advisory_unlock( hashtext('gormigrate:xml:notx') )`)
-
-		mntx := gormigrate.New(db, &gormigrate.Options{
+		mntx := gormigrate.New(ctxDB, &gormigrate.Options{
 			TableName:      schema + ".schema_migrations",
 			IDColumnName:   "id",
 			IDColumnSize:   255,
 			UseTransaction: false,
-		}, notxMigs)
+		}, retry.wrap(x.wrap(in.wrap(notxMigs), false), opts))
 
 		if err := doAction(mntx, sub, toID); err != nil {
-			return err
+			return in.err(err)
 		}
 
 		if err := syncMetadata(dbAdapter, schema, metasNoTx); err != nil {
 			return err
 		}
 	}
+
+	// repeatable changesets run after every versioned one has been applied
+	if sub == "up" {
+		if in.interrupted() {
+			return errInterrupted
+		}
+		if err := runRepeatables(ctxDB, schema, reps, rep, x); err != nil {
+			return in.err(err)
+		}
+	}
 	return nil
 }
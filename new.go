@@ -0,0 +1,173 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// changesetTemplates are the built-in templates of the new command, keyed by
+// "<kind>.<file>". A file of the same name in <folder>/templates overrides one.
+var changesetTemplates = map[string]string{
+	"sql.sql":      "-- {{.ID}}: forward migration. ${schema} is the changelog schema.\n",
+	"sql.down.sql": "-- {{.ID}}: undo everything {{.ID}}.sql does.\n",
+	"change.xml": `<createTable tableName="{{.Schema}}.{{.Name}}">
+    <column name="id" type="bigint" autoIncrement="true" primaryKey="true"/>
+</createTable>
+`,
+	"loadData.csv": "id\n",
+}
+
+// changesetFiles lists, per kind, the templates rendered into changeset/<id><suffix>.
+// change.xml is rendered into the <changeLog> element instead.
+var changesetFiles = map[string][]string{
+	"sql":      {".sql", ".down.sql"},
+	"change":   nil,
+	"loadData": {".csv"},
+}
+
+// templateData is available to changeset templates.
+type templateData struct {
+	ID, Name, Schema, Author, Labels string
+}
+
+// renderTemplate renders the named changeset template, preferring the one in
+// <folder>/templates.
+func renderTemplate(name string, data templateData) (string, error) {
+	text, ok := changesetTemplates[name]
+	b, err := os.ReadFile(filepath.Join(Folder.Path(), "templates", name))
+	switch {
+	case err == nil:
+		text = string(b)
+	case !os.IsNotExist(err):
+		return "", err
+	case !ok:
+		return "", fmt.Errorf("no template %s", name)
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// createFile writes content to a new file, refusing to overwrite an existing one.
+func createFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RunNew scaffolds a changeset: it picks the next id, creates its files under
+// changeset/ from the kind's templates and appends a <changeLog> to migrations.xml.
+func RunNew(cmd *cobra.Command, args []string) {
+	name := slug(args[0])
+	if name == "" {
+		log.Fatalf("invalid changeset name %q", args[0])
+		return
+	}
+	files, ok := changesetFiles[NewKind]
+	if !ok {
+		log.Fatalf("unsupported kind=%s (sql, change, loadData)", NewKind)
+		return
+	}
+
+	path := Folder.JoinPath("migrations.xml")
+	doc, _, err := parseXML(path)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	next, width := nextChangelogNumber(doc)
+	data := templateData{
+		ID:     fmt.Sprintf("%0*d_%s", width, next, name),
+		Name:   name,
+		Schema: doc.Schema,
+		Author: ChangelogAuthor,
+		Labels: NewLabels,
+	}
+	if data.Author == "" {
+		data.Author = defaultAuthor()
+	}
+	if data.Labels == "" {
+		data.Labels = name
+	}
+
+	dir := filepath.Join(filepath.Dir(path), "changeset")
+	if len(files) > 0 {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatal(err)
+			return
+		}
+	}
+	var created []string
+	for _, suffix := range files {
+		content, err := renderTemplate(NewKind+suffix, data)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		file := filepath.Join(dir, data.ID+suffix)
+		if err := createFile(file, content); err != nil {
+			log.Fatal(err)
+			return
+		}
+		created = append(created, file)
+	}
+
+	var body []string
+	switch NewKind {
+	case "sql":
+		body = []string{
+			fmt.Sprintf(`<include file="./changeset/%s.sql" relativeToChangelogFile="true"/>`, data.ID),
+			fmt.Sprintf(`<includeDown file="./changeset/%s.down.sql" relativeToChangelogFile="true"/>`, data.ID),
+		}
+	case "change":
+		content, err := renderTemplate("change.xml", data)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		body = strings.Split(strings.TrimRight(content, "\n"), "\n")
+	case "loadData":
+		body = []string{
+			fmt.Sprintf(`<table name="%s.%s"/>`, xmlAttr(data.Schema), data.Name),
+			fmt.Sprintf(`<loadData file="./changeset/%s.csv" relativeToChangelogFile="true" primaryKey="id"/>`, data.ID),
+		}
+	}
+
+	attrs := [][2]string{{"kind", NewKind}, {"author", data.Author}, {"labels", data.Labels}}
+	if NewNoTx {
+		attrs = append(attrs, [2]string{"transactional", "false"})
+	}
+	err = appendChangelogs(path, func(indent string) string {
+		return changelogEntry(indent, data.ID, attrs, body)
+	})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Added %s to %s\n", data.ID, path)
+	for _, f := range created {
+		fmt.Fprintf(out, "Created %s\n", f)
+	}
+}
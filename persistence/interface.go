@@ -1,11 +1,17 @@
 package persistence
 
-import "gorm.io/gorm"
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
 
 // DBConnector defines the interface for database connections
 type DBConnector interface {
 	Connect() (*gorm.DB, error)
 	Close() error
+	// Ping checks that the connection opened by Connect is alive
+	Ping(ctx context.Context) error
 	GetConfig() *DBConfig
 }
 
@@ -1,7 +1,9 @@
 package persistence
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -21,9 +23,9 @@ func NewMySQLConnector(config *DBConfig) *MySQLConnector {
 	}
 }
 
-// Connect establishes a connection to the MySQL database
-func (mc *MySQLConnector) Connect() (*gorm.DB, error) {
-
+// dsn returns the go-sql-driver DSN of the connection. Parameter values are
+// query escaped, as the driver unescapes them.
+func (mc *MySQLConnector) dsn() string {
 	var dsn string
 	if mc.config.Password != "" {
 		dsn = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
@@ -43,20 +45,21 @@ func (mc *MySQLConnector) Connect() (*gorm.DB, error) {
 	}
 
 	// Add custom parameters if any
-	if len(mc.config.Params) > 0 {
-		dsn += "&"
-		for key, value := range mc.config.Params {
-			dsn += fmt.Sprintf("%s=%s&", key, value)
-		}
-		// Remove the trailing &
-		dsn = dsn[:len(dsn)-1]
+	for key, value := range mc.config.Params {
+		dsn += fmt.Sprintf("&%s=%s", key, url.QueryEscape(value))
 	}
+	return dsn
+}
+
+// Connect establishes a connection to the MySQL database
+func (mc *MySQLConnector) Connect() (*gorm.DB, error) {
+	dsn := mc.dsn()
 
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Error),
+		Logger: mc.config.redactLogger(logger.Default.LogMode(logger.Error)),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MySQL database: %w", err)
+		return nil, fmt.Errorf("failed to connect to MySQL database: %w", mc.config.redactError(err))
 	}
 	mc.db = db
 
@@ -85,3 +88,15 @@ func (mc *MySQLConnector) Close() error {
 	}
 	return nil
 }
+
+// Ping checks that the MySQL server answers on the connection
+func (mc *MySQLConnector) Ping(ctx context.Context) error {
+	if mc.db == nil {
+		return fmt.Errorf("not connected")
+	}
+	sqlDB, err := mc.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get underlying *sql.DB: %v", err)
+	}
+	return mc.config.redactError(sqlDB.PingContext(ctx))
+}
@@ -1,7 +1,9 @@
 package persistence
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -22,15 +24,15 @@ func NewPostgresConnector(config *DBConfig) *PostgresConnector {
 	}
 }
 
-// Connect establishes a connection to the PostgreSQL database
-func (pc *PostgresConnector) Connect() (*gorm.DB, error) {
-	// Build connection string
+// dsn returns the key=value connection string of the connection. The password
+// and parameter values are quoted as libpq requires.
+func (pc *PostgresConnector) dsn() string {
 	var dsn string
 	if pc.config.Password != "" {
 		dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
 			pc.config.Host,
 			pc.config.Username,
-			pc.config.Password,
+			quoteConnValue(pc.config.Password),
 			pc.config.Database,
 			pc.config.Port,
 			pc.config.SSLMode,
@@ -48,20 +50,46 @@ func (pc *PostgresConnector) Connect() (*gorm.DB, error) {
 
 	// Add custom parameters if any
 	for key, value := range pc.config.Params {
-		dsn += fmt.Sprintf(" %s=%s", key, value)
+		dsn += fmt.Sprintf(" %s=%s", key, quoteConnValue(value))
+	}
+	return dsn
+}
+
+// quoteConnValue quotes a value of a key=value connection string when it is
+// empty or holds a space, quote or backslash.
+func quoteConnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n\r'\\") {
+		return v
 	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// Connect establishes a connection to the PostgreSQL database
+func (pc *PostgresConnector) Connect() (*gorm.DB, error) {
+	dsn := pc.dsn()
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Error),
+		Logger: pc.config.redactLogger(logger.Default.LogMode(logger.Error)),
 		NamingStrategy: schema.NamingStrategy{
 			TablePrefix: pc.config.Schema,
 		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", pc.config.redactError(err))
 	}
-
 	pc.db = db
+
+	// Configure connection pool
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	// Set connection pool settings
+	sqlDB.SetMaxIdleConns(pc.config.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(pc.config.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(pc.config.ConnMaxLifetime)
+
 	return db, nil
 }
 
@@ -76,3 +104,15 @@ func (pc *PostgresConnector) Close() error {
 	}
 	return nil
 }
+
+// Ping checks that the PostgreSQL server answers on the connection
+func (pc *PostgresConnector) Ping(ctx context.Context) error {
+	if pc.db == nil {
+		return fmt.Errorf("not connected")
+	}
+	sqlDB, err := pc.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get underlying *sql.DB: %v", err)
+	}
+	return pc.config.redactError(sqlDB.PingContext(ctx))
+}
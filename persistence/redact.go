@@ -0,0 +1,83 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// redactedPassword replaces the password in messages, as in url.URL.Redacted
+const redactedPassword = "xxxxx"
+
+// redact replaces the password of the config in s, also in its URL-escaped
+// and connection string quoted forms.
+func (c *DBConfig) redact(s string) string {
+	if c.Password == "" {
+		return s
+	}
+	for _, p := range []string{quoteConnValue(c.Password), c.Password, url.QueryEscape(c.Password), url.PathEscape(c.Password)} {
+		s = strings.ReplaceAll(s, p, redactedPassword)
+	}
+	return s
+}
+
+// redactedError is an error whose message has the password removed
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError returns err with the password removed from its message. The
+// original error is still reachable through errors.As.
+func (c *DBConfig) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := c.redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+	return err
+}
+
+// redactLogger removes the password from the messages gorm logs, such as
+// "failed to initialize database" with the driver error.
+type redactLogger struct {
+	logger.Interface
+	config *DBConfig
+}
+
+func (c *DBConfig) redactLogger(l logger.Interface) logger.Interface {
+	return redactLogger{Interface: l, config: c}
+}
+
+func (l redactLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return l.config.redactLogger(l.Interface.LogMode(level))
+}
+
+func (l redactLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Info(ctx, "%s", l.config.redact(fmt.Sprintf(msg, data...)))
+}
+
+func (l redactLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Warn(ctx, "%s", l.config.redact(fmt.Sprintf(msg, data...)))
+}
+
+func (l redactLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.Interface.Error(ctx, "%s", l.config.redact(fmt.Sprintf(msg, data...)))
+}
+
+func (l redactLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, l.config.redactError(err))
+}
@@ -0,0 +1,88 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// RunPing checks that the database accepts connections and reports its server
+// version and whether the migration table exists, for use as a readiness gate.
+func RunPing(cmd *cobra.Command, _ []string) {
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	schema, err := sourceSchema()
+	if err != nil {
+		schema = defaultSchema()
+	}
+
+	start := time.Now()
+	db, config, err := openDB(schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	latency := time.Since(start)
+
+	d, err := newDialect(config.Driver, schema)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	version, err := serverVersion(db, d)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	exists, err := migrationTableExists(db, d)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "database: ok (%s, connected in %s)\n", config.Redacted(), latency.Round(time.Millisecond))
+	fmt.Fprintf(out, "server: %s %s\n", d.driver, version)
+	if exists {
+		fmt.Fprintf(out, "migration table: %s\n", d.table("schema_migrations"))
+	} else {
+		fmt.Fprintf(out, "migration table: missing (%s)\n", d.table("schema_migrations"))
+	}
+}
+
+// serverVersion returns the version reported by the database server.
+func serverVersion(db *gorm.DB, d dialect) (string, error) {
+	query := `SELECT VERSION()`
+	if d.isPostgres() {
+		query = `SHOW server_version`
+	}
+	var version string
+	if err := db.Raw(query).Row().Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to read the server version: %w", err)
+	}
+	return version, nil
+}
+
+// migrationTableExists reports whether schema_migrations exists in the schema of d.
+func migrationTableExists(db *gorm.DB, d dialect) (bool, error) {
+	var exists bool
+	var err error
+	if d.isPostgres() {
+		err = db.Raw(`SELECT to_regclass(?) IS NOT NULL`, d.table("schema_migrations")).Row().Scan(&exists)
+	} else {
+		err = db.Raw(`SELECT COUNT(*) > 0 FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'schema_migrations'`).Row().Scan(&exists)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up the migration table: %w", err)
+	}
+	return exists, nil
+}
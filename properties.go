@@ -0,0 +1,152 @@
+package baselith
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// properties resolves ${name} placeholders in SQL files. Sources are consulted
+// in precedence order: CLI --param, BASELITH_PARAM_<NAME> environment variables,
+// YAML params, changelog <property> elements and finally the built-in properties.
+type properties struct {
+	cli       map[string]string
+	env       func(string) (string, bool)
+	yaml      map[string]string
+	changelog map[string]string
+	builtin   map[string]string
+}
+
+// newProperties builds the property set for doc. Only <property> elements
+// without a context, or whose context is active, are taken into account;
+// later definitions override earlier ones.
+func newProperties(doc *xmlMigrations, cli, yaml map[string]string, contexts string) *properties {
+	active := splitList(contexts)
+	changelog := make(map[string]string)
+	for _, p := range doc.Properties {
+		if p.Context != "" && !anyIn(splitList(p.Context), active) {
+			continue
+		}
+		changelog[p.Name] = p.Value
+	}
+
+	return &properties{
+		cli:       cli,
+		env:       os.LookupEnv,
+		yaml:      yaml,
+		changelog: changelog,
+		builtin: map[string]string{
+			"schema": doc.Schema,
+		},
+	}
+}
+
+// lookup returns the value of a property from the highest precedence source defining it.
+func (p *properties) lookup(name string) (string, bool) {
+	if v, ok := p.cli[name]; ok {
+		return v, true
+	}
+	if p.env != nil {
+		if v, ok := p.env(paramEnvName(name)); ok {
+			return v, true
+		}
+	}
+	if v, ok := p.yaml[name]; ok {
+		return v, true
+	}
+	if v, ok := p.changelog[name]; ok {
+		return v, true
+	}
+	v, ok := p.builtin[name]
+	return v, ok
+}
+
+// paramEnvName returns the environment variable of a property: the name upper
+// cased, with characters other than letters, digits and underscores replaced
+// by underscores, after BASELITH_PARAM_.
+func paramEnvName(name string) string {
+	return envPrefix + "PARAM_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// expand replaces every ${name} placeholder in text. "$${" is an escaped literal "${".
+// All unresolved placeholders are reported in a single error.
+func (p *properties) expand(text string) (string, error) {
+	if p == nil || !strings.Contains(text, "${") {
+		return text, nil
+	}
+
+	var (
+		b          strings.Builder
+		unresolved []string
+		seen       = make(map[string]bool)
+	)
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "$${"):
+			b.WriteString("${")
+			i += 3
+		case strings.HasPrefix(text[i:], "${"):
+			end := strings.IndexByte(text[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated placeholder at offset %d", i)
+			}
+			name := strings.TrimSpace(text[i+2 : i+2+end])
+			if v, ok := p.lookup(name); ok {
+				b.WriteString(v)
+			} else if !seen[name] {
+				seen[name] = true
+				unresolved = append(unresolved, "${"+name+"}")
+			}
+			i += 2 + end + 1
+		default:
+			b.WriteByte(text[i])
+			i++
+		}
+	}
+
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return "", fmt.Errorf("unresolved properties: %s", strings.Join(unresolved, ", "))
+	}
+	return b.String(), nil
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// labelsSelected reports whether a changeset with the given labels runs in an
+// up, down or to under --label-filter: always when no filter is set, else when
+// one label matches.
+func labelsSelected(labels string) bool {
+	filter := splitList(LabelFilter)
+	return len(filter) == 0 || anyIn(splitList(labels), filter)
+}
+
+// anyIn reports whether any of values is contained in set.
+func anyIn(values, set []string) bool {
+	for _, v := range values {
+		for _, s := range set {
+			if strings.EqualFold(v, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package baselith
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPropertiesPrecedence(t *testing.T) {
+	doc := &xmlMigrations{
+		Schema: "app",
+		Properties: []xmlProperty{
+			{Name: "owner", Value: "changelog"},
+			{Name: "tablespace", Value: "fast", Context: "prod"},
+			{Name: "tablespace", Value: "slow", Context: "dev"},
+			{Name: "region", Value: "eu"},
+			{Name: "region", Value: "us"},
+			{Name: "schema", Value: "overridden"},
+		},
+	}
+	cli := map[string]string{"owner": "cli"}
+	yaml := map[string]string{"owner": "yaml", "env_only": "yaml", "yaml_only": "yaml"}
+	env := map[string]string{"BASELITH_PARAM_OWNER": "env", "BASELITH_PARAM_ENV_ONLY": "env", "BASELITH_PARAM_APP_NAME": "env"}
+
+	p := newProperties(doc, cli, yaml, "prod")
+	p.env = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"owner", "cli"},
+		{"env_only", "env"},
+		{"app.name", "env"},
+		{"yaml_only", "yaml"},
+		{"tablespace", "fast"},
+		{"region", "us"},
+		{"schema", "overridden"},
+	}
+	for _, tt := range tests {
+		if got, ok := p.lookup(tt.name); !ok || got != tt.want {
+			t.Errorf("lookup(%q) = %q, %t, want %q", tt.name, got, ok, tt.want)
+		}
+	}
+
+	delete(cli, "owner")
+	if got, _ := newProperties(&xmlMigrations{Schema: "app"}, cli, nil, "").lookup("schema"); got != "app" {
+		t.Errorf("lookup(schema) = %q, want the changelog schema", got)
+	}
+}
+
+func TestPropertiesExpand(t *testing.T) {
+	p := &properties{yaml: map[string]string{"schema": "app", "owner": "deploy"}}
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  string
+	}{
+		{name: "no placeholder", in: "SELECT 1", want: "SELECT 1"},
+		{name: "placeholders", in: "ALTER TABLE ${schema}.t OWNER TO ${ owner };", want: "ALTER TABLE app.t OWNER TO deploy;"},
+		{name: "escaped", in: "SELECT '$${schema}', '${schema}'", want: "SELECT '${schema}', 'app'"},
+		{name: "dollar quotes untouched", in: "DO $$ BEGIN END $$", want: "DO $$ BEGIN END $$"},
+		{name: "unresolved", in: "${b} ${a} ${b}", err: "unresolved properties: ${a}, ${b}"},
+		{name: "unterminated", in: "SELECT ${schema", err: "unterminated placeholder at offset 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.expand(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expand(%q) error = %v, want %q", tt.in, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("expand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParamEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"owner":      "BASELITH_PARAM_OWNER",
+		"app.name":   "BASELITH_PARAM_APP_NAME",
+		"Read-Only2": "BASELITH_PARAM_READ_ONLY2",
+	} {
+		if got := paramEnvName(name); got != want {
+			t.Errorf("paramEnvName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package baselith
 
 import (
 	"encoding/xml"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,25 +15,45 @@ func parseXML(path string) (*xmlMigrations, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	// strict decoding: encoding/xml silently drops unknown attributes and elements
+	if issues, _ := scanXMLStructure(path, b); len(issues) > 0 {
+		msgs := make([]string, len(issues))
+		for i, is := range issues {
+			msgs[i] = is.String()
+		}
+		return nil, "", fmt.Errorf("invalid changelog:\n%s", strings.Join(msgs, "\n"))
+	}
 	var doc xmlMigrations
 	if err := xml.Unmarshal(b, &doc); err != nil {
 		return nil, "", err
 	}
+	if err := checkVersion(&doc); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
 	SortChangelogsByID(doc.Items)
 	base := filepath.Dir(path)
 	return &doc, base, nil
 }
 
-func readSQL(base, file string, relative bool) (string, error) {
-	p := file
+// includePath resolves an included file, relative to the changelog directory if requested.
+func includePath(base, file string, relative bool) string {
 	if relative {
-		p = filepath.Join(base, file)
+		return filepath.Join(base, file)
 	}
+	return file
+}
+
+func readSQL(base, file string, relative bool, props *properties) (string, error) {
+	p := includePath(base, file, relative)
 	b, err := os.ReadFile(p)
 	if err != nil {
 		return "", err
 	}
-	return string(b), nil
+	sql, err := props.expand(string(b))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+	return sql, nil
 }
 
 // SortChangelogsByID sorts a slice of xmlChangelog by their numeric ID prefix (e.g., "000", "001", ...)
@@ -0,0 +1,190 @@
+package baselith
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// repeatableMigration is a changelog marked runOnChange or runAlways. It is not
+// handled by gormigrate: it runs after all versioned changesets and its last
+// checksum and execution count are tracked in schema_migrations.
+type repeatableMigration struct {
+	ID       string
+	UpSQL    string
+	Checksum string
+	Always   bool
+	Meta     Meta
+	Options  changesetOptions
+}
+
+type repeatableRow struct {
+	ID        string
+	Checksum  *string
+	ExecCount int
+}
+
+// checksumSQL returns the hex encoded SHA-256 of a SQL script.
+func checksumSQL(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// readRepeatablesXML reads the repeatable changelogs of doc, in changelog order.
+func readRepeatablesXML(doc *xmlMigrations, baseDir string) ([]*repeatableMigration, error) {
+	var reps []*repeatableMigration
+	for _, m := range doc.Items {
+		if !m.repeatable() {
+			continue
+		}
+		if m.Kind != "sql" {
+			return nil, fmt.Errorf("%s: runOnChange/runAlways is only supported for kind=sql", m.ID)
+		}
+
+		upSQL, _, err := readChangelogSQL(m, baseDir, doc.props)
+		if err != nil {
+			return nil, err
+		}
+
+		verifySQL, err := readVerifySQL(m, baseDir, doc.props)
+		if err != nil {
+			return nil, err
+		}
+
+		useTx := true
+		if m.Transactional != nil {
+			useTx = *m.Transactional
+		}
+		timeout, lockTimeout, err := changesetTimeouts(m)
+		if err != nil {
+			return nil, err
+		}
+
+		reps = append(reps, &repeatableMigration{
+			ID:       m.ID,
+			UpSQL:    upSQL,
+			Checksum: checksumSQL(upSQL),
+			Always:   m.RunAlways,
+			Meta: Meta{
+				Author:        m.Author,
+				Labels:        m.Labels,
+				Kind:          m.Kind,
+				Transactional: useTx,
+			},
+			Options: changesetOptions{
+				Verify:      verifySQL,
+				Timeout:     timeout,
+				LockTimeout: lockTimeout,
+				Retryable:   m.Retryable,
+			},
+		})
+	}
+	return reps, nil
+}
+
+// loadRepeatableRows returns the recorded state of every row in schema_migrations, keyed by id.
+func loadRepeatableRows(db *gorm.DB, schema string) (map[string]repeatableRow, error) {
+	var rows []repeatableRow
+	if err := db.Raw(fmt.Sprintf(`SELECT id, checksum, exec_count FROM %s.schema_migrations`, schema)).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	state := make(map[string]repeatableRow, len(rows))
+	for _, r := range rows {
+		state[r.ID] = r
+	}
+	return state, nil
+}
+
+// needsRun reports whether a repeatable changeset must be executed given its recorded row.
+func (r *repeatableMigration) needsRun(row repeatableRow, found bool) bool {
+	if r.Always || !found || row.Checksum == nil {
+		return true
+	}
+	return *row.Checksum != r.Checksum
+}
+
+// recordRepeatable inserts or updates the schema_migrations row of a repeatable changeset.
+func recordRepeatable(db DBInterface, schema string, r *repeatableMigration, found bool) error {
+	var result DBResult
+	if found {
+		result = db.Exec(
+			fmt.Sprintf(`UPDATE %s.schema_migrations
+			             SET checksum = ?, exec_count = exec_count + 1, applied_at = CURRENT_TIMESTAMP,
+			                 author = ?, labels = ?, kind = ?, transactional = ?
+			             WHERE id = ?`, schema),
+			r.Checksum, r.Meta.Author, r.Meta.Labels, r.Meta.Kind, r.Meta.Transactional, r.ID,
+		)
+	} else {
+		result = db.Exec(
+			fmt.Sprintf(`INSERT INTO %s.schema_migrations (id, author, labels, kind, transactional, checksum, exec_count)
+			             VALUES (?, ?, ?, ?, ?, ?, 1)`, schema),
+			r.ID, r.Meta.Author, r.Meta.Labels, r.Meta.Kind, r.Meta.Transactional, r.Checksum,
+		)
+	}
+	if err := result.Error(); err != nil {
+		return fmt.Errorf("failed to record repeatable %q: %w", r.ID, err)
+	}
+	return nil
+}
+
+// runRepeatables executes every repeatable changeset whose checksum changed
+// (or that is marked runAlways) and records the new state. Every execution is
+// recorded in x.
+func runRepeatables(db *gorm.DB, schema string, reps []*repeatableMigration, rep *report, x *execLog) error {
+	if len(reps) == 0 {
+		return nil
+	}
+
+	state, err := loadRepeatableRows(db, schema)
+	if err != nil {
+		return err
+	}
+
+	retry := newRetrier(x.in)
+	for _, r := range reps {
+		row, found := state[r.ID]
+		if !r.needsRun(row, found) {
+			rep.addCase(reportCase{Name: r.ID, Status: caseSkipped, Message: "checksum unchanged"})
+			continue
+		}
+
+		start := time.Now()
+		log.Printf("Running repeatable changeset %s", r.ID)
+		apply := func(tx *gorm.DB) error {
+			if err := execStatements(tx, sqlStatements(r.UpSQL)); err != nil {
+				return fmt.Errorf("%s: %w", r.ID, err)
+			}
+			if r.Options.Verify != "" {
+				if err := runVerify(tx, r.ID, r.Options.Verify); err != nil {
+					return err
+				}
+			}
+			return recordRepeatable(NewDBAdapter(tx), schema, r, found)
+		}
+
+		apply = x.attempt(r.ID, "up", r.Meta.Transactional, withTimeouts(apply, r.Options.Timeout, r.Options.LockTimeout))
+		run := func() error {
+			if r.Meta.Transactional {
+				return db.Transaction(apply)
+			}
+			// one connection, so that session settings apply to every statement
+			return db.Connection(apply)
+		}
+		if r.Meta.Transactional || r.Options.Retryable {
+			err = retry.do(r.ID, run)
+		} else {
+			err = run()
+		}
+		if err != nil {
+			rep.addCase(reportCase{Name: r.ID, Status: caseFailed, Duration: time.Since(start), Message: err.Error()})
+			return err
+		}
+		rep.addCase(reportCase{Name: r.ID, Status: casePassed, Duration: time.Since(start)})
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package baselith
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Test case outcomes of a report.
+const (
+	casePassed  = "pass"
+	caseFailed  = "fail"
+	caseSkipped = "skipped"
+)
+
+// reportCase is one changeset of a report, rendered as a JUnit test case.
+type reportCase struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Message  string
+}
+
+// reportResult is one finding of a report, rendered as a SARIF result.
+type reportResult struct {
+	Rule     string
+	Severity string // error | warning | info
+	Message  string
+	File     string
+	Line     int
+}
+
+// report collects the outcome of validate, lint or a migration run for CI.
+// A nil *report records nothing, so callers need not check --report-format.
+type report struct {
+	command string
+	rules   map[string]string // rule id -> description
+	cases   []reportCase
+	index   map[string]int // case name -> position in cases
+	results []reportResult
+}
+
+// checkReportFormat rejects an unknown --report-format, so that the command
+// fails before doing any work rather than when writing the report.
+func checkReportFormat() error {
+	switch ReportFormat {
+	case "", "sarif", "junit":
+		return nil
+	default:
+		return fmt.Errorf("unsupported report format: %s (sarif, junit)", ReportFormat)
+	}
+}
+
+// newReport returns a report for command, or nil when no --report-format is set.
+func newReport(command string) *report {
+	if ReportFormat == "" {
+		return nil
+	}
+	return &report{command: command, rules: make(map[string]string), index: make(map[string]int)}
+}
+
+// addCase records c. A case of the same name, from an earlier attempt, is
+// replaced, keeping its position.
+func (r *report) addCase(c reportCase) {
+	if r == nil {
+		return
+	}
+	if i, ok := r.index[c.Name]; ok {
+		r.cases[i] = c
+		return
+	}
+	r.index[c.Name] = len(r.cases)
+	r.cases = append(r.cases, c)
+}
+
+// revertBatch marks the passed cases of migs as skipped once their
+// transactional batch has been rolled back by a later failure.
+func (r *report) revertBatch(migs []*gormigrate.Migration) {
+	if r == nil {
+		return
+	}
+	for _, gm := range migs {
+		if i, ok := r.index[gm.ID]; ok && r.cases[i].Status == casePassed {
+			r.cases[i].Status = caseSkipped
+			r.cases[i].Message = "rolled back with the transactional batch after a later failure"
+		}
+	}
+}
+
+func (r *report) addResult(res reportResult, description string) {
+	if r == nil {
+		return
+	}
+	r.results = append(r.results, res)
+	if _, ok := r.rules[res.Rule]; !ok {
+		r.rules[res.Rule] = description
+	}
+}
+
+// skipRemaining adds a skipped case for every id that has no case yet.
+func (r *report) skipRemaining(ids []string, message string) {
+	if r == nil {
+		return
+	}
+	for _, id := range ids {
+		if _, ok := r.index[id]; !ok {
+			r.addCase(reportCase{Name: id, Status: caseSkipped, Message: message})
+		}
+	}
+}
+
+// wrap times the Migrate and Rollback functions of migs and records each call
+// as a case; a retried changeset keeps the case of its last attempt.
+func (r *report) wrap(migs []*gormigrate.Migration) []*gormigrate.Migration {
+	if r == nil {
+		return migs
+	}
+	timed := func(name string, fn func(*gorm.DB) error) func(*gorm.DB) error {
+		if fn == nil {
+			return nil
+		}
+		return func(tx *gorm.DB) error {
+			start := time.Now()
+			err := fn(tx)
+			c := reportCase{Name: name, Status: casePassed, Duration: time.Since(start)}
+			if err != nil {
+				c.Status, c.Message = caseFailed, err.Error()
+			}
+			r.addCase(c)
+			return err
+		}
+	}
+
+	out := make([]*gormigrate.Migration, len(migs))
+	for i, gm := range migs {
+		out[i] = &gormigrate.Migration{
+			ID:       gm.ID,
+			Migrate:  timed(gm.ID, gm.Migrate),
+			Rollback: timed(gm.ID, gm.Rollback),
+		}
+	}
+	return out
+}
+
+// write renders the report in --report-format to --report-file, or stdout when no file is set.
+func (r *report) write(stdout io.Writer) error {
+	if r == nil {
+		return nil
+	}
+
+	w := stdout
+	if ReportFile != "" {
+		f, err := os.Create(ReportFile)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch ReportFormat {
+	case "sarif":
+		return r.writeSARIF(w)
+	case "junit":
+		return r.writeJUnit(w)
+	default:
+		return fmt.Errorf("unsupported report format: %s (sarif, junit)", ReportFormat)
+	}
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+func (r *report) writeSARIF(w io.Writer) error {
+	ids := make([]string, 0, len(r.rules))
+	for id := range r.rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	driver := sarifDriver{Name: "baselith", InformationURI: "https://github.com/hinha/baselith", Rules: []sarifRule{}}
+	for _, id := range ids {
+		driver.Rules = append(driver.Rules, sarifRule{ID: id, ShortDescription: sarifMessage{Text: r.rules[id]}})
+	}
+
+	results := make([]sarifResult, 0, len(r.results))
+	for _, res := range r.results {
+		level := res.Severity
+		if level == severityInfo {
+			level = "note"
+		}
+		sr := sarifResult{RuleID: res.Rule, Level: level, Message: sarifMessage{Text: res.Message}}
+		if res.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(res.File)}}}
+			if res.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: res.Line}
+			}
+			sr.Locations = []sarifLocation{loc}
+		}
+		results = append(results, sr)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func (r *report) writeJUnit(w io.Writer) error {
+	suite := junitTestSuite{Name: "baselith " + r.command, Tests: len(r.cases)}
+	var total time.Duration
+	for _, c := range r.cases {
+		tc := junitTestCase{
+			Name:      c.Name,
+			Classname: "baselith." + r.command,
+			Time:      fmt.Sprintf("%.3f", c.Duration.Seconds()),
+		}
+		switch c.Status {
+		case caseFailed:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: firstLine(c.Message), Text: c.Message}
+		case caseSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: c.Message}
+		}
+		total += c.Duration
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func firstLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package baselith
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// retryableSQLStates are the Postgres errors that may succeed when the
+// transaction is run again.
+var retryableSQLStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available (lock_timeout)
+}
+
+// retryableMySQLErrors are the MySQL errors that may succeed when the
+// transaction is run again.
+var retryableMySQLErrors = map[uint16]bool{
+	1205: true, // ER_LOCK_WAIT_TIMEOUT
+	1213: true, // ER_LOCK_DEADLOCK
+	3572: true, // ER_LOCK_NOWAIT
+}
+
+// isRetryable reports whether err is a transient server error.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if s := sqlState(err); s != "" {
+		return retryableSQLStates[s]
+	}
+	return retryableMySQLErrors[mysqlErrorNumber(err)]
+}
+
+// retrier runs work again on transient errors, per --retries, --retry-backoff
+// and --retry-max-elapsed. The delay doubles after every attempt.
+type retrier struct {
+	retries    int
+	backoff    time.Duration
+	maxElapsed time.Duration
+	in         *interrupter // nil outside a migration run
+}
+
+func newRetrier(in *interrupter) *retrier {
+	return &retrier{retries: Retries, backoff: RetryBackoff, maxElapsed: RetryMaxElapsed, in: in}
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable, or
+// the retries or the elapsed time are exhausted.
+func (r *retrier) do(what string, fn func() error) error {
+	start := time.Now()
+	delay := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || errors.Is(err, errInterrupted) || attempt > r.retries {
+			return err
+		}
+		if r.maxElapsed > 0 && time.Since(start)+delay > r.maxElapsed {
+			log.Printf("%s: not retrying, --retry-max-elapsed %s would be exceeded", what, r.maxElapsed)
+			return err
+		}
+		log.Printf("%s failed with a transient error (attempt %d of %d), retrying in %s: %v", what, attempt, r.retries+1, delay, err)
+
+		if r.in == nil {
+			time.Sleep(delay)
+		} else {
+			select {
+			case <-r.in.wait.Done():
+				return err
+			case <-time.After(delay):
+			}
+			if r.in.interrupted() {
+				return err
+			}
+		}
+		delay *= 2
+	}
+}
+
+// wrap retries the Migrate and Rollback functions of the migrations whose
+// changelog is marked retryable. Transactional changesets are retried as a
+// batch instead, since a failed statement aborts the whole transaction.
+func (r *retrier) wrap(migs []*gormigrate.Migration, opts map[string]changesetOptions) []*gormigrate.Migration {
+	retried := func(id string, fn func(*gorm.DB) error) func(*gorm.DB) error {
+		if fn == nil || !opts[id].Retryable {
+			return fn
+		}
+		return func(tx *gorm.DB) error {
+			return r.do(id, func() error { return fn(tx) })
+		}
+	}
+	out := make([]*gormigrate.Migration, len(migs))
+	for i, gm := range migs {
+		out[i] = &gormigrate.Migration{
+			ID:       gm.ID,
+			Migrate:  retried(gm.ID, gm.Migrate),
+			Rollback: retried(gm.ID, gm.Rollback),
+		}
+	}
+	return out
+}
@@ -0,0 +1,274 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// roundTrip applies gm, rolls it back, checks that the schema matches the
+// snapshot taken before applying it, and re-applies it. fatal is set when the
+// database is left in a state where the following changesets cannot be tested.
+func roundTrip(db *gorm.DB, driver, schema string, gm *gormigrate.Migration, useTx bool) (problem string, fatal bool) {
+	runner := func() *gormigrate.Gormigrate {
+		return gormigrate.New(db, &gormigrate.Options{
+			TableName:      schema + ".schema_migrations",
+			IDColumnName:   "id",
+			IDColumnSize:   255,
+			UseTransaction: useTx,
+		}, []*gormigrate.Migration{gm})
+	}
+
+	before, err := takeSnapshot(db, driver, schema)
+	if err != nil {
+		return fmt.Sprintf("snapshot before up: %v", err), true
+	}
+	if err := runner().Migrate(); err != nil {
+		return fmt.Sprintf("up failed: %v", err), true
+	}
+
+	if err := runner().RollbackLast(); err != nil {
+		// the changeset stays applied, so the next ones can still be tested
+		return fmt.Sprintf("rollback missing or failed: %v", err), false
+	}
+
+	after, err := takeSnapshot(db, driver, schema)
+	if err != nil {
+		return fmt.Sprintf("snapshot after down: %v", err), true
+	}
+	missing, extra := compareLines(before.lines(), after.lines())
+
+	if err := runner().Migrate(); err != nil {
+		return fmt.Sprintf("re-apply after rollback failed: %v", err), true
+	}
+
+	if len(missing) > 0 || len(extra) > 0 {
+		var b strings.Builder
+		b.WriteString("rollback is not a true inverse of up:")
+		for _, l := range missing {
+			b.WriteString("\n    - " + l)
+		}
+		for _, l := range extra {
+			b.WriteString("\n    + " + l)
+		}
+		return b.String(), false
+	}
+	return "", false
+}
+
+// retargetSchema rewrites the table names of doc qualified with schema from
+// to schema to. A table of any other schema is an error, as test-rollback
+// must only touch its scratch schema.
+func retargetSchema(doc *xmlMigrations, from, to string) error {
+	for i := range doc.Items {
+		m := &doc.Items[i]
+		var names []*string
+		if m.Table != nil {
+			names = append(names, &m.Table.Name)
+		}
+		if m.LoadData != nil {
+			names = append(names, &m.LoadData.Table)
+		}
+		for j := range m.Changes {
+			c := &m.Changes[j]
+			names = append(names, &c.TableName, &c.BaseTableName, &c.ReferencedTableName)
+		}
+
+		for _, name := range names {
+			dot := strings.LastIndexByte(*name, '.')
+			if dot < 0 {
+				continue
+			}
+			if (*name)[:dot] != from {
+				return fmt.Errorf("%s: table %s is outside the changelog schema %s; test-rollback refuses to run changesets that touch other schemas", m.ID, *name, from)
+			}
+			*name = to + (*name)[dot:]
+		}
+	}
+	return nil
+}
+
+// checkSQLSchema refuses SQL files that name schema explicitly. search_path and
+// USE do not redirect qualified names, so such a file would change the real
+// schema instead of the scratch one.
+func checkSQLSchema(doc *xmlMigrations, baseDir, schema string) error {
+	// unquoted names are case insensitive
+	qualified := regexp.MustCompile("(?i)" + schemaQualifier(schema).String())
+	for _, m := range doc.Items {
+		var includes []*xmlInclude
+		if m.Kind == "sql" {
+			includes = append(includes, m.IncludeUp)
+		}
+		includes = append(includes, m.IncludeDown, m.IncludeVerify)
+		for _, inc := range includes {
+			if inc == nil {
+				continue
+			}
+			sql, err := readSQL(baseDir, inc.File, inc.Rel, doc.props)
+			if err != nil {
+				return fmt.Errorf("%s: %w", m.ID, err)
+			}
+			if qualified.MatchString(sql) {
+				return fmt.Errorf("%s: %s names the schema %s; use ${schema} so that test-rollback runs it in the scratch schema", m.ID, inc.File, schema)
+			}
+		}
+	}
+	return nil
+}
+
+// RunTestRollback applies, rolls back and re-applies every changeset in a
+// disposable schema, reporting changesets whose rollback is missing or not a
+// true inverse of their up script.
+func RunTestRollback(cmd *cobra.Command, _ []string) {
+	if err := checkReportFormat(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := loadConnectionConfig(); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	path := Folder.JoinPath("migrations.xml")
+	doc, baseDir, err := parseXML(path)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	scratch := ScratchSchema
+	if scratch == "" {
+		scratch = doc.Schema + "_rollback_test"
+	}
+	// every ${schema} placeholder, generated statement and table qualified with
+	// the changelog schema targets the scratch schema
+	schema := doc.Schema
+	if err := retargetSchema(doc, schema, scratch); err != nil {
+		log.Fatal(err)
+		return
+	}
+	doc.Schema = scratch
+	doc.props = newProperties(doc, Params, configParams, Contexts)
+	Schema = scratch
+	if err := checkSQLSchema(doc, baseDir, schema); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	txMigs, notxMigs, metasTx, _, _, err := readMigrationsXML(doc, baseDir)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	db, config, err := openDB(scratch)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get database instance:", err)
+		return
+	}
+	defer sqlDB.Close()
+	// a single connection keeps search_path / USE for the whole run
+	sqlDB.SetMaxOpenConns(1)
+
+	d, err := newDialect(config.Driver, scratch)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := db.Exec("CREATE SCHEMA " + d.quote(scratch)).Error; err != nil {
+		log.Fatalf("Failed to create scratch schema %s (drop it or pick another with --scratch-schema): %v", scratch, err)
+		return
+	}
+	// log.Fatal skips deferred calls, so the scratch schema is dropped explicitly
+	cleanup := func() {
+		if KeepScratch {
+			log.Printf("Keeping scratch schema %s", scratch)
+			return
+		}
+		drop := "DROP SCHEMA " + d.quote(scratch)
+		if d.isPostgres() {
+			drop += " CASCADE"
+		}
+		if err := db.Exec(drop).Error; err != nil {
+			log.Printf("Failed to drop scratch schema %s: %v", scratch, err)
+		}
+	}
+
+	if d.isPostgres() {
+		err = db.Exec("SET search_path TO " + d.quote(scratch)).Error
+	} else {
+		err = db.Exec("USE " + d.quote(scratch)).Error
+	}
+	if err == nil {
+		err = migrationTable(config.Driver, NewDBAdapter(db))
+	}
+	if err != nil {
+		cleanup()
+		log.Fatal("Failed to prepare scratch schema:", err)
+		return
+	}
+
+	// test in changelog order, whichever batch the changeset belongs to
+	byID := make(map[string]*gormigrate.Migration, len(txMigs)+len(notxMigs))
+	for _, gm := range append(txMigs, notxMigs...) {
+		byID[gm.ID] = gm
+	}
+
+	out := cmd.OutOrStdout()
+	rep := newReport("test-rollback")
+	var ids []string
+	failed := 0
+	stopped := false
+	for _, m := range doc.Items {
+		gm, ok := byID[m.ID]
+		if !ok {
+			continue // repeatable changesets have no rollback
+		}
+		ids = append(ids, m.ID)
+		if stopped {
+			continue
+		}
+
+		_, useTx := metasTx[m.ID]
+		start := time.Now()
+		problem, fatal := roundTrip(db, config.Driver, scratch, gm, useTx)
+		elapsed := time.Since(start)
+
+		if problem == "" {
+			if ReportFormat == "" || ReportFile != "" {
+				fmt.Fprintf(out, "✓ %s\n", m.ID)
+			}
+			rep.addCase(reportCase{Name: m.ID, Status: casePassed, Duration: elapsed})
+			continue
+		}
+		failed++
+		if ReportFormat == "" || ReportFile != "" {
+			fmt.Fprintf(out, "✗ %s\t%s\n", m.ID, problem)
+		}
+		rep.addCase(reportCase{Name: m.ID, Status: caseFailed, Duration: elapsed, Message: problem})
+		if fatal {
+			log.Printf("Stopping: %s left the scratch schema in an unknown state", m.ID)
+			stopped = true
+		}
+	}
+	rep.skipRemaining(ids, "not tested: an earlier changeset failed")
+	if err := rep.write(out); err != nil {
+		log.Println(err)
+	}
+	cleanup()
+
+	if failed > 0 {
+		log.Fatalf("%d changeset(s) failed the rollback round trip", failed)
+	}
+}
@@ -1,6 +1,7 @@
 package baselith
 
 import (
+	"encoding/xml"
 	"fmt"
 	"log"
 	"time"
@@ -21,29 +22,115 @@ type Meta struct {
 	Transactional bool
 }
 
+// changesetOptions are the settings of a changeset that only matter while it
+// runs; unlike Meta they are not stored in schema_migrations.
+type changesetOptions struct {
+	Verify      string        // SQL of the <includeVerify> file, empty if none
+	Timeout     time.Duration // statement timeout, 0 for none
+	LockTimeout time.Duration // lock wait timeout, 0 for none
+	Retryable   bool          // retried on transient errors even when not transactional
+}
+
 type xmlMigrations struct {
-	Schema string         `xml:"schema,attr"`
-	Items  []xmlChangelog `xml:"changeLog"`
+	Schema     string         `xml:"schema,attr"`
+	Version    string         `xml:"version,attr"` // changelog format version, default "1"
+	Properties []xmlProperty  `xml:"property"`
+	Items      []xmlChangelog `xml:"changeLog"`
+
+	props *properties // resolved at load time
+}
+
+type xmlProperty struct {
+	Name    string `xml:"name,attr"`
+	Value   string `xml:"value,attr"`
+	Context string `xml:"context,attr"` // comma separated, empty = always
 }
 
 type xmlChangelog struct {
-	ID            string      `xml:"id,attr"`
-	Kind          string      `xml:"kind,attr"` // "struct" | "sql"
-	Author        string      `xml:"author,attr"`
-	Labels        string      `xml:"labels,attr"`
-	Transactional *bool       `xml:"transactional,attr"` // default: true
-	Table         *xmlTable   `xml:"table"`
-	IncludeUp     *xmlInclude `xml:"include"`
-	IncludeDown   *xmlInclude `xml:"includeDown"`
+	ID            string       `xml:"id,attr"`
+	Kind          string       `xml:"kind,attr"` // "struct" | "sql"
+	Author        string       `xml:"author,attr"`
+	Labels        string       `xml:"labels,attr"`
+	Transactional *bool        `xml:"transactional,attr"` // default: true
+	RunOnChange   bool         `xml:"runOnChange,attr"`   // re-run when the checksum changes
+	RunAlways     bool         `xml:"runAlways,attr"`     // re-run on every "up"
+	Timeout       string       `xml:"timeout,attr"`       // statement timeout, e.g. "30s"
+	LockTimeout   string       `xml:"lockTimeout,attr"`   // lock wait timeout, e.g. "5s"
+	Retryable     bool         `xml:"retryable,attr"`     // retry transient failures outside a transaction
+	Table         *xmlTable    `xml:"table"`
+	IncludeUp     *xmlInclude  `xml:"include"`
+	IncludeDown   *xmlInclude  `xml:"includeDown"`
+	IncludeVerify *xmlInclude  `xml:"includeVerify"`
+	LoadData      *xmlLoadData `xml:"loadData"`
+	Lint          *xmlLint     `xml:"lint"`
+	Changes       []xmlChange  `xml:",any"` // declarative change types, in document order
+}
+
+// repeatable reports whether the changelog is re-executed after the versioned ones.
+func (c xmlChangelog) repeatable() bool {
+	return c.RunOnChange || c.RunAlways
 }
 
 type xmlTable struct {
 	Name string `xml:"name,attr"` // e.g. "public.m_roles"
 }
 
+// xmlChange holds the attributes of every declarative change type
+// (createTable, addColumn, ...); XMLName selects which ones apply.
+type xmlChange struct {
+	XMLName               xml.Name
+	TableName             string      `xml:"tableName,attr"`
+	ColumnName            string      `xml:"columnName,attr"`
+	ColumnDataType        string      `xml:"columnDataType,attr"`
+	OldColumnName         string      `xml:"oldColumnName,attr"`
+	NewColumnName         string      `xml:"newColumnName,attr"`
+	IndexName             string      `xml:"indexName,attr"`
+	Unique                bool        `xml:"unique,attr"`
+	BaseTableName         string      `xml:"baseTableName,attr"`
+	BaseColumnNames       string      `xml:"baseColumnNames,attr"`
+	ReferencedTableName   string      `xml:"referencedTableName,attr"`
+	ReferencedColumnNames string      `xml:"referencedColumnNames,attr"`
+	ConstraintName        string      `xml:"constraintName,attr"`
+	OnDelete              string      `xml:"onDelete,attr"`
+	OnUpdate              string      `xml:"onUpdate,attr"`
+	DefaultNullValue      *string     `xml:"defaultNullValue,attr"`
+	Columns               []xmlColumn `xml:"column"`
+}
+
+type xmlColumn struct {
+	Name                 string  `xml:"name,attr"`
+	Type                 string  `xml:"type,attr"`
+	AutoIncrement        bool    `xml:"autoIncrement,attr"`
+	PrimaryKey           bool    `xml:"primaryKey,attr"`
+	Unique               bool    `xml:"unique,attr"`
+	Nullable             *bool   `xml:"nullable,attr"` // default: true
+	DefaultValue         *string `xml:"defaultValue,attr"`
+	DefaultValueComputed string  `xml:"defaultValueComputed,attr"`
+}
+
+type xmlLoadData struct {
+	File       string          `xml:"file,attr"`
+	Rel        bool            `xml:"relativeToChangelogFile,attr"` // "true"/"false" or "1"/"0"
+	Table      string          `xml:"table,attr"`                   // defaults to <table name>
+	PrimaryKey string          `xml:"primaryKey,attr"`              // comma separated
+	Mode       string          `xml:"mode,attr"`                    // "insert" (default) | "upsert"
+	BatchSize  int             `xml:"batchSize,attr"`
+	Separator  string          `xml:"separator,attr"`
+	Columns    []xmlLoadColumn `xml:"column"`
+}
+
+type xmlLoadColumn struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"` // "string" | "numeric" | "boolean" | "date" | "computed" | "skip"
+}
+
+type xmlLint struct {
+	Ignore string `xml:"ignore,attr"` // comma separated lint rule ids
+}
+
 type xmlInclude struct {
 	File string `xml:"file,attr"`
-	Rel  string `xml:"relativeToChangelogFile,attr"` // "true"/"false"
+	Rel  bool   `xml:"relativeToChangelogFile,attr"` // "true"/"false" or "1"/"0"
 }
 
 type migRow struct {
@@ -77,54 +164,93 @@ func syncMetadata(db DBInterface, schema string, metas map[string]Meta) error {
 	return nil
 }
 
-func cmdHistory(db *gorm.DB) error {
+// loadMigRows returns the rows of schema_migrations for the configured driver.
+func loadMigRows(db *gorm.DB) ([]migRow, error) {
 	var rows []migRow
 	if Driver == "postgres" || Driver == "postgresql" {
 		if err := db.Raw(fmt.Sprintf(sqlPostgresSchema, Schema)).
 			Scan(&rows).Error; err != nil {
-			return err
+			return nil, err
 		}
 	} else if Driver == "mysql" {
 		if err := db.Raw(sqlMysqlSchema).
 			Scan(&rows).Error; err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return rows, nil
+}
+
+func cmdHistory(db *gorm.DB) error {
+	rows, err := loadMigRows(db)
+	if err != nil {
+		return err
+	}
+	attempts, err := loadLogRows(db, Schema, "outcome IN (?, ?, ?) OR dirty", outcomeFailed, outcomeInterrupted, outcomeTimeout)
+	if err != nil {
+		return err
+	}
 
 	log.Println("== Migration History ==")
 	for _, r := range rows {
 		fmt.Printf("%s\t%s\n", r.AppliedAt.Format(time.RFC3339), r.ID)
 	}
+	if len(attempts) > 0 {
+		log.Println("== Failed, Timed Out and Interrupted Executions ==")
+	}
+	for _, l := range attempts {
+		fmt.Println(l)
+	}
 	return nil
 }
 
-func cmdStatus(db *gorm.DB, all []*gormigrate.Migration) error {
-	var rows []migRow
-	if Driver == "postgres" || Driver == "postgresql" {
-		if err := db.Raw(fmt.Sprintf(sqlPostgresSchema, Schema)).
-			Scan(&rows).Error; err != nil {
-			return err
-		}
-	} else if Driver == "mysql" {
-		if err := db.Raw(sqlMysqlSchema).
-			Scan(&rows).Error; err != nil {
-			return err
-		}
+func cmdStatus(db *gorm.DB, all []*gormigrate.Migration, reps []*repeatableMigration) error {
+	rows, err := loadMigRows(db)
+	if err != nil {
+		return err
 	}
 	applied := map[string]time.Time{}
 	for _, r := range rows {
 		applied[r.ID] = r.AppliedAt
 	}
+	dirtyRows, err := loadLogRows(db, Schema, "dirty")
+	if err != nil {
+		return err
+	}
+	dirty := map[string]logRow{}
+	for _, l := range dirtyRows {
+		dirty[l.ID] = l
+	}
 
 	log.Println("== Migration IsActive ==")
 	for _, gm := range all {
-		if t, ok := applied[gm.ID]; ok {
+		if l, ok := dirty[gm.ID]; ok {
+			log.Printf("✗ %s\t(DIRTY: %s failed at %s, run repair)\n", gm.ID, l.Direction, l.StartedAt.Format(time.RFC3339))
+		} else if t, ok := applied[gm.ID]; ok {
 			log.Printf("✓ %s\t(%s)\n", gm.ID, t.Format(time.RFC3339))
 		} else {
 			log.Printf("• %s\t(PENDING)\n", gm.ID)
 		}
 	}
 
+	if len(reps) > 0 {
+		state, err := loadRepeatableRows(db, Schema)
+		if err != nil {
+			return err
+		}
+		for _, r := range reps {
+			row, found := state[r.ID]
+			switch {
+			case !found:
+				log.Printf("• %s\t(PENDING, repeatable)\n", r.ID)
+			case r.needsRun(row, found):
+				log.Printf("↻ %s\t(CHANGED, repeatable, runs: %d)\n", r.ID, row.ExecCount)
+			default:
+				log.Printf("✓ %s\t(%s, repeatable, runs: %d)\n", r.ID, applied[r.ID].Format(time.RFC3339), row.ExecCount)
+			}
+		}
+	}
+
 	// optional: info drift (ada di DB tapi tidak ada di XML)
 	for id := range applied {
 		found := false
@@ -134,6 +260,12 @@ func cmdStatus(db *gorm.DB, all []*gormigrate.Migration) error {
 				break
 			}
 		}
+		for _, r := range reps {
+			if r.ID == id {
+				found = true
+				break
+			}
+		}
 		if !found {
 			log.Printf("! drift: applied but missing in XML -> %s\n", id)
 		}
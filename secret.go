@@ -0,0 +1,77 @@
+package baselith
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// resolvePassword returns the password of c: read from password_file, the
+// variable named by password_env or the output of password_command when one
+// of them is set, else the password itself. At most one may be set.
+func (c *DBConfigYAML) resolvePassword() (string, error) {
+	var set []string
+	for _, s := range []struct{ key, value string }{
+		{"password", c.Password},
+		{"password_file", c.PasswordFile},
+		{"password_env", c.PasswordEnv},
+		{"password_command", c.PasswordCommand},
+	} {
+		if s.value != "" {
+			set = append(set, s.key)
+		}
+	}
+	if len(set) > 1 {
+		return "", fmt.Errorf("only one of %s may be set", strings.Join(set, ", "))
+	}
+
+	switch {
+	case c.PasswordFile != "":
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("password_file: %w", err)
+		}
+		return nonEmptySecret("password_file "+c.PasswordFile, string(b))
+	case c.PasswordEnv != "":
+		v, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password_env: environment variable %s is not set", c.PasswordEnv)
+		}
+		return nonEmptySecret("password_env "+c.PasswordEnv, v)
+	case c.PasswordCommand != "":
+		return runPasswordCommand(c.PasswordCommand)
+	}
+	return c.Password, nil
+}
+
+// runPasswordCommand runs command with the system shell and returns what it
+// printed. Its stdin and stderr are those of baselith, for commands that prompt.
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var out bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password_command %q: %w", command, err)
+	}
+	return nonEmptySecret("password_command "+fmt.Sprintf("%q", command), out.String())
+}
+
+// nonEmptySecret strips the trailing newline of a secret read from source and
+// fails when nothing is left.
+func nonEmptySecret(source, secret string) (string, error) {
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s: empty password", source)
+	}
+	return secret, nil
+}
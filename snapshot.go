@@ -0,0 +1,503 @@
+package baselith
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// schemaSnapshot is the introspected structure of one schema, sorted by name.
+type schemaSnapshot struct {
+	Driver    string             `json:"driver"`
+	Schema    string             `json:"schema"`
+	Sequences []sequenceSnapshot `json:"sequences,omitempty"`
+	Tables    []tableSnapshot    `json:"tables"`
+	Views     []viewSnapshot     `json:"views,omitempty"`
+	Functions []functionSnapshot `json:"functions,omitempty"`
+}
+
+type tableSnapshot struct {
+	Name        string               `json:"name"`
+	Columns     []columnSnapshot     `json:"columns"`
+	Indexes     []indexSnapshot      `json:"indexes,omitempty"`
+	Constraints []constraintSnapshot `json:"constraints,omitempty"`
+}
+
+type columnSnapshot struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Nullable bool    `json:"nullable"`
+	Default  *string `json:"default,omitempty"`
+	Identity string  `json:"identity,omitempty"` // ALWAYS | BY DEFAULT | AUTO_INCREMENT
+	Position int     `json:"-"`
+}
+
+type indexSnapshot struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	Unique     bool     `json:"unique,omitempty"`
+	Definition string   `json:"definition"`
+}
+
+type constraintSnapshot struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"` // PRIMARY KEY | UNIQUE | FOREIGN KEY | CHECK
+	Definition string `json:"definition"`
+	RefTable   string `json:"refTable,omitempty"`
+}
+
+type sequenceSnapshot struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Start     int64  `json:"start"`
+	Increment int64  `json:"increment"`
+	Min       int64  `json:"min"`
+	Max       int64  `json:"max"`
+	Cycle     bool   `json:"cycle,omitempty"`
+}
+
+type viewSnapshot struct {
+	Name         string `json:"name"`
+	Materialized bool   `json:"materialized,omitempty"`
+	Definition   string `json:"definition"`
+}
+
+type functionSnapshot struct {
+	Name       string `json:"name"` // includes the argument types, overloads are distinct
+	Kind       string `json:"kind"` // FUNCTION | PROCEDURE
+	Definition string `json:"definition"`
+}
+
+// table returns the snapshot of the named table, or nil.
+func (s *schemaSnapshot) table(name string) *tableSnapshot {
+	for i := range s.Tables {
+		if s.Tables[i].Name == name {
+			return &s.Tables[i]
+		}
+	}
+	return nil
+}
+
+// isMigrationTable reports whether a table belongs to baselith itself and is left out of snapshots.
+func isMigrationTable(name string) bool {
+	return strings.HasPrefix(name, "schema_migrations")
+}
+
+// takeSnapshot introspects schema through information_schema/pg_catalog.
+func takeSnapshot(db *gorm.DB, driver, schema string) (*schemaSnapshot, error) {
+	d, err := newDialect(driver, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &schemaSnapshot{Driver: d.driver, Schema: schema}
+	if d.isPostgres() {
+		err = snapshotPostgres(db, schema, snap)
+	} else {
+		err = snapshotMySQL(db, schema, snap)
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap.sort()
+	return snap, nil
+}
+
+// sort orders every object by name (columns by position) so that dumps are deterministic.
+func (s *schemaSnapshot) sort() {
+	sort.Slice(s.Sequences, func(i, j int) bool { return s.Sequences[i].Name < s.Sequences[j].Name })
+	sort.Slice(s.Tables, func(i, j int) bool { return s.Tables[i].Name < s.Tables[j].Name })
+	sort.Slice(s.Views, func(i, j int) bool { return s.Views[i].Name < s.Views[j].Name })
+	sort.Slice(s.Functions, func(i, j int) bool { return s.Functions[i].Name < s.Functions[j].Name })
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		sort.SliceStable(t.Columns, func(i, j int) bool { return t.Columns[i].Position < t.Columns[j].Position })
+		sort.Slice(t.Indexes, func(i, j int) bool { return t.Indexes[i].Name < t.Indexes[j].Name })
+		sort.Slice(t.Constraints, func(i, j int) bool { return t.Constraints[i].Name < t.Constraints[j].Name })
+	}
+}
+
+// tableIndex returns a lookup that creates missing tables on demand.
+func (s *schemaSnapshot) tableIndex() func(string) *tableSnapshot {
+	return func(name string) *tableSnapshot {
+		if t := s.table(name); t != nil {
+			return t
+		}
+		s.Tables = append(s.Tables, tableSnapshot{Name: name})
+		return &s.Tables[len(s.Tables)-1]
+	}
+}
+
+const (
+	sqlPostgresTables = `SELECT c.relname AS name
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = ? AND c.relkind IN ('r', 'p')`
+
+	sqlPostgresColumns = `SELECT c.relname AS table_name, a.attname AS name,
+       format_type(a.atttypid, a.atttypmod) AS type,
+       NOT a.attnotnull AS nullable,
+       pg_get_expr(d.adbin, d.adrelid) AS "default",
+       CASE a.attidentity WHEN 'a' THEN 'ALWAYS' WHEN 'd' THEN 'BY DEFAULT' ELSE '' END AS identity,
+       a.attnum AS position
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE n.nspname = ? AND c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped`
+
+	sqlPostgresIndexes = `SELECT t.relname AS table_name, i.relname AS name, ix.indisunique AS "unique",
+       pg_get_indexdef(ix.indexrelid) AS definition,
+       array_to_string(ARRAY(
+           SELECT pg_get_indexdef(ix.indexrelid, k + 1, true)
+           FROM generate_subscripts(ix.indkey, 1) AS k ORDER BY k), ',') AS columns
+FROM pg_index ix
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN pg_class t ON t.oid = ix.indrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE n.nspname = ?
+  AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = ix.indexrelid AND con.contype IN ('p', 'u'))`
+
+	sqlPostgresConstraints = `SELECT t.relname AS table_name, con.conname AS name,
+       CASE con.contype WHEN 'p' THEN 'PRIMARY KEY' WHEN 'u' THEN 'UNIQUE'
+                        WHEN 'f' THEN 'FOREIGN KEY' WHEN 'c' THEN 'CHECK' ELSE con.contype::text END AS type,
+       pg_get_constraintdef(con.oid) AS definition,
+       COALESCE(r.relname, '') AS ref_table
+FROM pg_constraint con
+JOIN pg_class t ON t.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+LEFT JOIN pg_class r ON r.oid = con.confrelid
+WHERE n.nspname = ? AND con.contype IN ('p', 'u', 'f', 'c')`
+
+	// identity sequences belong to their column and are rendered with it
+	sqlPostgresSequences = `SELECT c.relname AS name, format_type(s.seqtypid, NULL) AS type,
+       s.seqstart AS start, s.seqincrement AS increment, s.seqmin AS min, s.seqmax AS max, s.seqcycle AS cycle
+FROM pg_sequence s
+JOIN pg_class c ON c.oid = s.seqrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = ?
+  AND NOT EXISTS (SELECT 1 FROM pg_depend dep WHERE dep.objid = c.oid AND dep.deptype = 'i')`
+
+	sqlPostgresViews = `SELECT c.relname AS name, c.relkind = 'm' AS materialized,
+       pg_get_viewdef(c.oid, true) AS definition
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = ? AND c.relkind IN ('v', 'm')`
+
+	// functions created by extensions are left to the extension
+	sqlPostgresFunctions = `SELECT p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')' AS name,
+       CASE p.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END AS kind,
+       pg_get_functiondef(p.oid) AS definition
+FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = ? AND p.prokind IN ('f', 'p')
+  AND NOT EXISTS (SELECT 1 FROM pg_depend dep WHERE dep.objid = p.oid AND dep.deptype = 'e')`
+)
+
+type introspectedColumn struct {
+	TableName string
+	Name      string
+	Type      string
+	Nullable  bool
+	Default   *string
+	Identity  string
+	Position  int
+}
+
+type introspectedIndex struct {
+	TableName  string
+	Name       string
+	Unique     bool
+	Definition string
+	Columns    string
+}
+
+type introspectedConstraint struct {
+	TableName  string
+	Name       string
+	Type       string
+	Definition string
+	RefTable   string
+}
+
+func snapshotPostgres(db *gorm.DB, schema string, snap *schemaSnapshot) error {
+	table := snap.tableIndex()
+
+	var tables []string
+	if err := db.Raw(sqlPostgresTables, schema).Scan(&tables).Error; err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	for _, name := range tables {
+		if !isMigrationTable(name) {
+			table(name)
+		}
+	}
+
+	var cols []introspectedColumn
+	if err := db.Raw(sqlPostgresColumns, schema).Scan(&cols).Error; err != nil {
+		return fmt.Errorf("failed to list columns: %w", err)
+	}
+	for _, c := range cols {
+		if isMigrationTable(c.TableName) {
+			continue
+		}
+		t := table(c.TableName)
+		t.Columns = append(t.Columns, columnSnapshot{
+			Name: c.Name, Type: c.Type, Nullable: c.Nullable, Default: c.Default, Identity: c.Identity, Position: c.Position,
+		})
+	}
+
+	var idxs []introspectedIndex
+	if err := db.Raw(sqlPostgresIndexes, schema).Scan(&idxs).Error; err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	for _, ix := range idxs {
+		if isMigrationTable(ix.TableName) {
+			continue
+		}
+		t := table(ix.TableName)
+		t.Indexes = append(t.Indexes, indexSnapshot{
+			Name: ix.Name, Columns: splitList(ix.Columns), Unique: ix.Unique, Definition: ix.Definition,
+		})
+	}
+
+	var cons []introspectedConstraint
+	if err := db.Raw(sqlPostgresConstraints, schema).Scan(&cons).Error; err != nil {
+		return fmt.Errorf("failed to list constraints: %w", err)
+	}
+	for _, c := range cons {
+		if isMigrationTable(c.TableName) {
+			continue
+		}
+		t := table(c.TableName)
+		t.Constraints = append(t.Constraints, constraintSnapshot{
+			Name: c.Name, Type: c.Type, Definition: c.Definition, RefTable: c.RefTable,
+		})
+	}
+
+	if err := db.Raw(sqlPostgresSequences, schema).Scan(&snap.Sequences).Error; err != nil {
+		return fmt.Errorf("failed to list sequences: %w", err)
+	}
+	if err := db.Raw(sqlPostgresViews, schema).Scan(&snap.Views).Error; err != nil {
+		return fmt.Errorf("failed to list views: %w", err)
+	}
+	if err := db.Raw(sqlPostgresFunctions, schema).Scan(&snap.Functions).Error; err != nil {
+		return fmt.Errorf("failed to list functions: %w", err)
+	}
+	return nil
+}
+
+const (
+	sqlMySQLTables = `SELECT TABLE_NAME AS name FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE'`
+
+	sqlMySQLColumns = `SELECT TABLE_NAME AS table_name, COLUMN_NAME AS name, COLUMN_TYPE AS type,
+       IS_NULLABLE = 'YES' AS nullable, COLUMN_DEFAULT AS ` + "`default`" + `,
+       IF(EXTRA LIKE '%auto_increment%', 'AUTO_INCREMENT', '') AS identity, ORDINAL_POSITION AS position
+FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ?`
+
+	sqlMySQLIndexes = `SELECT TABLE_NAME AS table_name, INDEX_NAME AS name, NON_UNIQUE = 0 AS ` + "`unique`" + `,
+       GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX SEPARATOR ',') AS columns
+FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = ? AND INDEX_NAME <> 'PRIMARY'
+  AND INDEX_NAME NOT IN (SELECT CONSTRAINT_NAME FROM information_schema.TABLE_CONSTRAINTS
+                         WHERE TABLE_SCHEMA = ? AND TABLE_NAME = STATISTICS.TABLE_NAME)
+GROUP BY TABLE_NAME, INDEX_NAME, NON_UNIQUE`
+
+	sqlMySQLConstraints = `SELECT tc.TABLE_NAME AS table_name, tc.CONSTRAINT_NAME AS name, tc.CONSTRAINT_TYPE AS type,
+       GROUP_CONCAT(k.COLUMN_NAME ORDER BY k.ORDINAL_POSITION SEPARATOR ',') AS columns,
+       COALESCE(MAX(k.REFERENCED_TABLE_NAME), '') AS ref_table,
+       COALESCE(GROUP_CONCAT(k.REFERENCED_COLUMN_NAME ORDER BY k.ORDINAL_POSITION SEPARATOR ','), '') AS ref_columns,
+       COALESCE(MAX(rc.DELETE_RULE), '') AS on_delete,
+       COALESCE(MAX(rc.UPDATE_RULE), '') AS on_update
+FROM information_schema.TABLE_CONSTRAINTS tc
+LEFT JOIN information_schema.KEY_COLUMN_USAGE k
+       ON k.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND k.TABLE_NAME = tc.TABLE_NAME AND k.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+LEFT JOIN information_schema.REFERENTIAL_CONSTRAINTS rc
+       ON rc.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND rc.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+WHERE tc.TABLE_SCHEMA = ? AND tc.CONSTRAINT_TYPE IN ('PRIMARY KEY', 'UNIQUE', 'FOREIGN KEY')
+GROUP BY tc.TABLE_NAME, tc.CONSTRAINT_NAME, tc.CONSTRAINT_TYPE`
+
+	sqlMySQLViews = `SELECT TABLE_NAME AS name, VIEW_DEFINITION AS definition
+FROM information_schema.VIEWS WHERE TABLE_SCHEMA = ?`
+
+	sqlMySQLRoutines = `SELECT r.ROUTINE_NAME AS name, r.ROUTINE_TYPE AS kind,
+       COALESCE(r.DTD_IDENTIFIER, '') AS returns, COALESCE(r.ROUTINE_DEFINITION, '') AS body,
+       COALESCE((SELECT GROUP_CONCAT(CONCAT_WS(' ', p.PARAMETER_MODE, p.PARAMETER_NAME, p.DTD_IDENTIFIER)
+                                     ORDER BY p.ORDINAL_POSITION SEPARATOR ', ')
+                 FROM information_schema.PARAMETERS p
+                 WHERE p.SPECIFIC_SCHEMA = r.ROUTINE_SCHEMA AND p.SPECIFIC_NAME = r.SPECIFIC_NAME
+                   AND p.ORDINAL_POSITION > 0), '') AS args
+FROM information_schema.ROUTINES r WHERE r.ROUTINE_SCHEMA = ?`
+)
+
+type introspectedRoutine struct {
+	Name    string
+	Kind    string
+	Returns string
+	Body    string
+	Args    string
+}
+
+type introspectedMySQLConstraint struct {
+	TableName  string
+	Name       string
+	Type       string
+	Columns    string
+	RefTable   string
+	RefColumns string
+	OnDelete   string
+	OnUpdate   string
+}
+
+func snapshotMySQL(db *gorm.DB, schema string, snap *schemaSnapshot) error {
+	d := dialect{driver: "mysql"}
+	table := snap.tableIndex()
+
+	var tables []string
+	if err := db.Raw(sqlMySQLTables, schema).Scan(&tables).Error; err != nil {
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	for _, name := range tables {
+		if !isMigrationTable(name) {
+			table(name)
+		}
+	}
+
+	var cols []introspectedColumn
+	if err := db.Raw(sqlMySQLColumns, schema).Scan(&cols).Error; err != nil {
+		return fmt.Errorf("failed to list columns: %w", err)
+	}
+	for _, c := range cols {
+		if isMigrationTable(c.TableName) || snap.table(c.TableName) == nil {
+			continue // views are listed in information_schema.COLUMNS as well
+		}
+		t := table(c.TableName)
+		t.Columns = append(t.Columns, columnSnapshot{
+			Name: c.Name, Type: c.Type, Nullable: c.Nullable, Default: c.Default, Identity: c.Identity, Position: c.Position,
+		})
+	}
+
+	var idxs []introspectedIndex
+	if err := db.Raw(sqlMySQLIndexes, schema, schema).Scan(&idxs).Error; err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	for _, ix := range idxs {
+		if isMigrationTable(ix.TableName) {
+			continue
+		}
+		unique := ""
+		if ix.Unique {
+			unique = "UNIQUE "
+		}
+		t := table(ix.TableName)
+		t.Indexes = append(t.Indexes, indexSnapshot{
+			Name:    ix.Name,
+			Columns: splitList(ix.Columns),
+			Unique:  ix.Unique,
+			Definition: fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+				unique, d.quote(ix.Name), d.quote(ix.TableName), d.quoteList(ix.Columns)),
+		})
+	}
+
+	var cons []introspectedMySQLConstraint
+	if err := db.Raw(sqlMySQLConstraints, schema).Scan(&cons).Error; err != nil {
+		return fmt.Errorf("failed to list constraints: %w", err)
+	}
+	for _, c := range cons {
+		if isMigrationTable(c.TableName) {
+			continue
+		}
+		def := fmt.Sprintf("%s (%s)", c.Type, d.quoteList(c.Columns))
+		if c.Type == "FOREIGN KEY" {
+			def += fmt.Sprintf(" REFERENCES %s (%s)", d.quote(c.RefTable), d.quoteList(c.RefColumns))
+			if c.OnDelete != "" && c.OnDelete != "NO ACTION" && c.OnDelete != "RESTRICT" {
+				def += " ON DELETE " + c.OnDelete
+			}
+			if c.OnUpdate != "" && c.OnUpdate != "NO ACTION" && c.OnUpdate != "RESTRICT" {
+				def += " ON UPDATE " + c.OnUpdate
+			}
+		}
+		t := table(c.TableName)
+		t.Constraints = append(t.Constraints, constraintSnapshot{
+			Name: c.Name, Type: c.Type, Definition: def, RefTable: c.RefTable,
+		})
+	}
+
+	if err := db.Raw(sqlMySQLViews, schema).Scan(&snap.Views).Error; err != nil {
+		return fmt.Errorf("failed to list views: %w", err)
+	}
+
+	var routines []introspectedRoutine
+	if err := db.Raw(sqlMySQLRoutines, schema).Scan(&routines).Error; err != nil {
+		return fmt.Errorf("failed to list functions: %w", err)
+	}
+	for _, r := range routines {
+		def := fmt.Sprintf("CREATE %s %s(%s)", r.Kind, d.quote(r.Name), r.Args)
+		if r.Kind == "FUNCTION" {
+			def += " RETURNS " + r.Returns
+		}
+		snap.Functions = append(snap.Functions, functionSnapshot{
+			Name: r.Name, Kind: r.Kind, Definition: def + "\n" + r.Body,
+		})
+	}
+	return nil
+}
+
+// lines renders the snapshot as one line per object attribute, used to compare snapshots.
+func (s *schemaSnapshot) lines() []string {
+	var out []string
+	for _, q := range s.Sequences {
+		out = append(out, fmt.Sprintf("sequence %s %s start %d increment %d min %d max %d cycle %t",
+			q.Name, q.Type, q.Start, q.Increment, q.Min, q.Max, q.Cycle))
+	}
+	for _, t := range s.Tables {
+		out = append(out, "table "+t.Name)
+		for _, c := range t.Columns {
+			line := fmt.Sprintf("column %s.%s %s", t.Name, c.Name, c.Type)
+			if !c.Nullable {
+				line += " NOT NULL"
+			}
+			if c.Default != nil {
+				line += " DEFAULT " + *c.Default
+			}
+			if c.Identity != "" {
+				line += " IDENTITY " + c.Identity
+			}
+			out = append(out, line)
+		}
+		for _, ix := range t.Indexes {
+			out = append(out, fmt.Sprintf("index %s.%s %s", t.Name, ix.Name, ix.Definition))
+		}
+		for _, c := range t.Constraints {
+			out = append(out, fmt.Sprintf("constraint %s.%s %s", t.Name, c.Name, c.Definition))
+		}
+	}
+	for _, v := range s.Views {
+		out = append(out, fmt.Sprintf("view %s materialized %t %s", v.Name, v.Materialized, strings.Join(strings.Fields(v.Definition), " ")))
+	}
+	for _, f := range s.Functions {
+		out = append(out, fmt.Sprintf("%s %s %s", strings.ToLower(f.Kind), f.Name, strings.Join(strings.Fields(f.Definition), " ")))
+	}
+	return out
+}
+
+// compareLines returns the lines only in a (missing from b) and only in b (extra in b).
+func compareLines(a, b []string) (missing, extra []string) {
+	inA := make(map[string]bool, len(a))
+	for _, l := range a {
+		inA[l] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, l := range b {
+		inB[l] = true
+		if !inA[l] {
+			extra = append(extra, l)
+		}
+	}
+	for _, l := range a {
+		if !inB[l] {
+			missing = append(missing, l)
+		}
+	}
+	return missing, extra
+}
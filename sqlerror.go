@@ -0,0 +1,31 @@
+package baselith
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// sqlState returns the Postgres SQLSTATE of err, or "" when err is not a server error.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// isUniqueViolation reports whether err is a unique or primary key violation.
+func isUniqueViolation(err error) bool {
+	return sqlState(err) == "23505" || mysqlErrorNumber(err) == 1062 // unique_violation, ER_DUP_ENTRY
+}
+
+// mysqlErrorNumber returns the MySQL error number of err, or 0 when err is not a server error.
+func mysqlErrorNumber(err error) uint16 {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number
+	}
+	return 0
+}
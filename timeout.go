@@ -0,0 +1,184 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// timeoutError is a changeset statement cancelled by the server because it ran
+// longer than its statement timeout or waited longer than its lock timeout.
+type timeoutError struct {
+	Kind  string // "statement" | "lock"
+	Limit time.Duration
+	Err   error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s timeout of %s exceeded: %v", e.Kind, e.Limit, e.Err)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.Err
+}
+
+// changesetTimeouts returns the statement and lock timeouts of a changelog:
+// its timeout and lockTimeout attributes, else --statement-timeout and
+// --statement-lock-timeout. Zero means no limit. On MySQL a timeout attribute
+// is logged as not enforced, since it only limits SELECT statements there.
+func changesetTimeouts(m xmlChangelog) (statement, lock time.Duration, err error) {
+	statement, lock = StatementTimeout, StatementLockTimeout
+	if m.Timeout != "" {
+		if statement, err = parseTimeout(m.Timeout); err != nil {
+			return 0, 0, fmt.Errorf("%s: timeout: %w", m.ID, err)
+		}
+	}
+	if m.LockTimeout != "" {
+		if lock, err = parseTimeout(m.LockTimeout); err != nil {
+			return 0, 0, fmt.Errorf("%s: lockTimeout: %w", m.ID, err)
+		}
+	}
+	if d, derr := newDialect(Driver, ""); derr == nil && !d.isPostgres() && m.Timeout != "" && statement > 0 {
+		log.Printf("%s: timeout=%q is not enforced for DDL or data changes on MySQL, max_execution_time only limits SELECT statements", m.ID, m.Timeout)
+	}
+	return statement, lock, nil
+}
+
+// parseTimeout parses a timeout attribute such as "30s" or "5m".
+func parseTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", v)
+	}
+	return d, nil
+}
+
+// inTransaction reports whether tx runs inside a transaction.
+func inTransaction(tx *gorm.DB) bool {
+	_, ok := tx.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// withTimeouts wraps fn so that it runs under the statement and lock timeouts,
+// restoring the previous session settings afterwards. Timeouts reported by the
+// server are returned as *timeoutError.
+func withTimeouts(fn func(*gorm.DB) error, statement, lock time.Duration) func(*gorm.DB) error {
+	if fn == nil || (statement == 0 && lock == 0) {
+		return fn
+	}
+	return func(tx *gorm.DB) error {
+		d, err := newDialect(tx.Dialector.Name(), "")
+		if err != nil {
+			return err
+		}
+		restore, err := d.setTimeouts(tx, statement, lock)
+		if err != nil {
+			return fmt.Errorf("failed to set timeouts: %w", err)
+		}
+
+		err = fn(tx)
+		if err != nil {
+			if kind := d.timeoutKind(err); kind != "" {
+				limit := statement
+				if kind == "lock" {
+					limit = lock
+				}
+				err = &timeoutError{Kind: kind, Limit: limit, Err: err}
+			}
+		}
+		// an aborted transaction is rolled back together with its settings
+		if rerr := restore(); rerr != nil && err == nil {
+			return fmt.Errorf("failed to restore timeouts: %w", rerr)
+		}
+		return err
+	}
+}
+
+// setTimeouts applies the non-zero timeouts to the session of tx, local to the
+// transaction when there is one, and returns a function restoring the previous values.
+func (d dialect) setTimeouts(tx *gorm.DB, statement, lock time.Duration) (func() error, error) {
+	if d.isPostgres() {
+		local := inTransaction(tx)
+		var restores []func() error
+		for _, s := range []struct {
+			name  string
+			limit time.Duration
+		}{{"statement_timeout", statement}, {"lock_timeout", lock}} {
+			if s.limit == 0 {
+				continue
+			}
+			var prev string
+			if err := tx.Raw(`SELECT current_setting(?)`, s.name).Row().Scan(&prev); err != nil {
+				return nil, err
+			}
+			if err := tx.Exec(`SELECT set_config(?, ?, ?)`, s.name, fmt.Sprint(s.limit.Milliseconds()), local).Error; err != nil {
+				return nil, err
+			}
+			name := s.name
+			restores = append(restores, func() error {
+				return tx.Exec(`SELECT set_config(?, ?, ?)`, name, prev, local).Error
+			})
+		}
+		return func() error {
+			for _, r := range restores {
+				if err := r(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+
+	// MySQL settings are per session; max_execution_time is in milliseconds
+	// (and only applies to SELECT), the lock waits in whole seconds.
+	// innodb_lock_wait_timeout covers row locks and lock_wait_timeout the
+	// metadata locks that DDL such as ALTER TABLE waits for.
+	var prevExec, prevLock, prevMeta int64
+	if err := tx.Raw(`SELECT @@SESSION.max_execution_time, @@SESSION.innodb_lock_wait_timeout, @@SESSION.lock_wait_timeout`).Row().
+		Scan(&prevExec, &prevLock, &prevMeta); err != nil {
+		return nil, err
+	}
+	var sets []string
+	if statement > 0 {
+		sets = append(sets, fmt.Sprintf("max_execution_time = %d", statement.Milliseconds()))
+	}
+	if lock > 0 {
+		secs := int64((lock + time.Second - 1) / time.Second)
+		sets = append(sets, fmt.Sprintf("innodb_lock_wait_timeout = %d", secs), fmt.Sprintf("lock_wait_timeout = %d", secs))
+	}
+	if err := tx.Exec("SET SESSION " + strings.Join(sets, ", ")).Error; err != nil {
+		return nil, err
+	}
+	return func() error {
+		return tx.Exec(fmt.Sprintf("SET SESSION max_execution_time = %d, innodb_lock_wait_timeout = %d, lock_wait_timeout = %d",
+			prevExec, prevLock, prevMeta)).Error
+	}, nil
+}
+
+// timeoutKind classifies a server error as a "statement" or "lock" timeout, or "".
+func (d dialect) timeoutKind(err error) string {
+	if d.isPostgres() {
+		switch sqlState(err) {
+		case "57014": // query_canceled, also raised by pg_cancel_backend
+			if strings.Contains(err.Error(), "statement timeout") {
+				return "statement"
+			}
+		case "55P03": // lock_not_available
+			return "lock"
+		}
+		return ""
+	}
+	switch mysqlErrorNumber(err) {
+	case 3024: // ER_QUERY_TIMEOUT
+		return "statement"
+	case 1205: // ER_LOCK_WAIT_TIMEOUT
+		return "lock"
+	}
+	return ""
+}
@@ -0,0 +1,399 @@
+package baselith
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// elementSpec lists the attributes and child elements allowed on a changelog element.
+type elementSpec struct {
+	attrs    []string
+	children []string
+}
+
+var includeSpec = elementSpec{attrs: []string{"file", "relativeToChangelogFile"}}
+
+var columnSpec = elementSpec{attrs: []string{
+	"name", "type", "autoIncrement", "primaryKey", "unique", "nullable", "defaultValue", "defaultValueComputed",
+}}
+
+// xmlSpec describes the changelog format. Elements are looked up by
+// "parent/name" first, then by name.
+var xmlSpec = map[string]elementSpec{
+	"migrations": {attrs: []string{"schema", "version"}, children: []string{"property", "changeLog"}},
+	"property":   {attrs: []string{"name", "value", "context"}},
+	"changeLog": {
+		attrs: []string{"id", "kind", "author", "labels", "transactional", "runOnChange", "runAlways", "timeout", "lockTimeout", "retryable"},
+		children: []string{
+			"table", "include", "includeDown", "includeVerify", "loadData", "lint",
+			"createTable", "addColumn", "dropColumn", "renameColumn", "createIndex", "addForeignKey", "addNotNullConstraint",
+		},
+	},
+	"table":         {attrs: []string{"name"}},
+	"lint":          {attrs: []string{"ignore"}},
+	"include":       includeSpec,
+	"includeDown":   includeSpec,
+	"includeVerify": includeSpec,
+	"loadData": {
+		attrs:    []string{"file", "relativeToChangelogFile", "table", "primaryKey", "mode", "batchSize", "separator"},
+		children: []string{"column"},
+	},
+	"loadData/column":      {attrs: []string{"name", "type"}},
+	"column":               columnSpec,
+	"createTable":          {attrs: []string{"tableName"}, children: []string{"column"}},
+	"addColumn":            {attrs: []string{"tableName"}, children: []string{"column"}},
+	"dropColumn":           {attrs: []string{"tableName", "columnName", "columnDataType"}, children: []string{"column"}},
+	"renameColumn":         {attrs: []string{"tableName", "oldColumnName", "newColumnName", "columnDataType"}},
+	"createIndex":          {attrs: []string{"tableName", "indexName", "unique"}, children: []string{"column"}},
+	"addForeignKey":        {attrs: []string{"baseTableName", "baseColumnNames", "referencedTableName", "referencedColumnNames", "constraintName", "onDelete", "onUpdate"}},
+	"addNotNullConstraint": {attrs: []string{"tableName", "columnName", "columnDataType", "defaultNullValue"}},
+}
+
+func lookupSpec(parent, name string) (elementSpec, bool) {
+	if spec, ok := xmlSpec[parent+"/"+name]; ok {
+		return spec, true
+	}
+	spec, ok := xmlSpec[name]
+	return spec, ok
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// issue is a problem found in a changelog.
+type issue struct {
+	File    string
+	Line    int
+	ID      string // changeset id, empty for file level problems
+	Message string
+}
+
+func (i issue) String() string {
+	loc := i.File
+	if i.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+	if i.ID != "" {
+		return fmt.Sprintf("%s: %s: %s", loc, i.ID, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", loc, i.Message)
+}
+
+// scanXMLStructure walks the XML tokens of a changelog, reporting unknown elements
+// and attributes, and returns the line of each <changeLog> in document order.
+func scanXMLStructure(path string, b []byte) (issues []issue, changelogLines []int) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	var stack []string
+	for {
+		line, _ := dec.InputPos()
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			line, _ = dec.InputPos()
+			return append(issues, issue{File: path, Line: line, Message: err.Error()}), changelogLines
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			var parent, grandparent string
+			if n := len(stack); n > 0 {
+				parent = stack[n-1]
+				if n > 1 {
+					grandparent = stack[n-2]
+				}
+			}
+
+			if parent == "" {
+				if name != "migrations" {
+					issues = append(issues, issue{File: path, Line: line, Message: fmt.Sprintf("unexpected root element <%s>, want <migrations>", name)})
+				}
+			} else if pspec, ok := lookupSpec(grandparent, parent); ok && !contains(pspec.children, name) {
+				issues = append(issues, issue{File: path, Line: line, Message: fmt.Sprintf("unknown element <%s> in <%s>", name, parent)})
+				if err := dec.Skip(); err != nil {
+					line, _ = dec.InputPos()
+					return append(issues, issue{File: path, Line: line, Message: err.Error()}), changelogLines
+				}
+				continue
+			}
+
+			stack = append(stack, name)
+			if name == "changeLog" {
+				changelogLines = append(changelogLines, line)
+			}
+
+			spec, ok := lookupSpec(parent, name)
+			if !ok {
+				continue
+			}
+			for _, a := range t.Attr {
+				// namespace declarations and xsi:* attributes are allowed anywhere
+				if a.Name.Space != "" || a.Name.Local == "xmlns" || contains(spec.attrs, a.Name.Local) {
+					continue
+				}
+				issues = append(issues, issue{File: path, Line: line, Message: fmt.Sprintf("unknown attribute %q on <%s>", a.Name.Local, name)})
+			}
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return issues, changelogLines
+}
+
+// changelogSource is a changelog parsed for offline checks. Items are kept in
+// document order so that lines[i] is the line of doc.Items[i].
+type changelogSource struct {
+	path    string
+	baseDir string
+	doc     *xmlMigrations
+	lines   []int
+}
+
+func (s *changelogSource) line(i int) int {
+	if i < len(s.lines) {
+		return s.lines[i]
+	}
+	return 0
+}
+
+// readChangelogSource reads the changelog at path, returning its structural problems.
+// src is nil when the XML cannot be decoded at all.
+func readChangelogSource(path string) (*changelogSource, []issue, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issues, lines := scanXMLStructure(path, b)
+
+	var doc xmlMigrations
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		// structural errors were already reported by scanXMLStructure; else
+		// the error is in a value, such as transactional="yes"
+		if len(issues) == 0 {
+			issues = append(issues, issue{File: path, Line: decodeErrorLine(b, lines), Message: err.Error()})
+		}
+		return nil, issues, nil
+	}
+	if err := checkVersion(&doc); err != nil {
+		issues = append(issues, issue{File: path, Line: 1, Message: err.Error()})
+	}
+	doc.props = newProperties(&doc, Params, configParams, Contexts)
+
+	return &changelogSource{path: path, baseDir: filepath.Dir(path), doc: &doc, lines: lines}, issues, nil
+}
+
+// decodeErrorLine returns the line of the first <changeLog> that cannot be
+// decoded, given the changelog lines found by scanXMLStructure, or 1.
+func decodeErrorLine(b []byte, lines []int) int {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	depth, k := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return 1
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth != 2 || t.Name.Local != "changeLog" {
+				continue
+			}
+			var m xmlChangelog
+			if err := dec.DecodeElement(&m, &t); err != nil {
+				if k < len(lines) {
+					return lines[k]
+				}
+				return 1
+			}
+			depth--
+			k++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// validateChangelog parses the changelog at path and every file it includes,
+// returning all problems found. It never connects to the database.
+func validateChangelog(path string) ([]issue, error) {
+	src, issues, err := readChangelogSource(path)
+	if err != nil || src == nil {
+		return issues, err
+	}
+	doc, baseDir := src.doc, src.baseDir
+
+	d, err := newDialect(Driver, doc.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]int)
+	for i, m := range doc.Items {
+		line := src.line(i)
+		add := func(format string, args ...any) {
+			issues = append(issues, issue{File: path, Line: line, ID: m.ID, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if m.ID == "" {
+			add("missing id")
+		} else if first, dup := seen[m.ID]; dup {
+			add("duplicate id, first defined at line %d", first)
+		} else {
+			seen[m.ID] = line
+		}
+		if m.Author == "" {
+			add("missing author")
+		}
+		if m.Labels == "" {
+			add("missing labels")
+		}
+
+		checkFile := func(role string, inc *xmlInclude, required bool) {
+			if inc == nil {
+				if required {
+					add("missing <%s> file", role)
+				}
+				return
+			}
+			if inc.File == "" {
+				add("<%s> missing file attribute", role)
+				return
+			}
+			sql, err := readSQL(baseDir, inc.File, inc.Rel, doc.props)
+			if err != nil {
+				add("<%s>: %v", role, err)
+				return
+			}
+			if strings.TrimSpace(sql) == "" {
+				add("<%s> %s is empty", role, inc.File)
+			}
+		}
+
+		switch m.Kind {
+		case "":
+			add("missing kind")
+		case "sql":
+			checkFile("include", m.IncludeUp, true)
+			if !m.repeatable() {
+				if m.IncludeDown == nil {
+					add("missing rollback: no <includeDown>")
+				} else {
+					checkFile("includeDown", m.IncludeDown, false)
+				}
+			}
+		case "change":
+			if m.repeatable() {
+				add("runOnChange/runAlways is only supported for kind=sql")
+			}
+			_, _, noDown, err := buildChanges(d, m)
+			if err != nil {
+				add("%v", strings.TrimPrefix(err.Error(), m.ID+": "))
+			}
+			if m.IncludeDown != nil {
+				checkFile("includeDown", m.IncludeDown, false)
+			} else if noDown != "" {
+				add("missing rollback: <%s> has no automatic inverse, add an <includeDown>", noDown)
+			}
+		case "loadData":
+			if m.repeatable() {
+				add("runOnChange/runAlways is only supported for kind=sql")
+			}
+			if ld, err := newLoadData(d, m, baseDir); err != nil {
+				add("%v", strings.TrimPrefix(err.Error(), m.ID+": "))
+			} else if len(ld.pk) == 0 {
+				add("missing rollback: <loadData> without primaryKey cannot be rolled back")
+			}
+		default:
+			add("unknown kind=%s", m.Kind)
+		}
+
+		checkFile("includeVerify", m.IncludeVerify, false)
+		if _, _, err := changesetTimeouts(m); err != nil {
+			add("%v", strings.TrimPrefix(err.Error(), m.ID+": "))
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	return issues, nil
+}
+
+// RunValidate validates the changelog offline and exits non-zero when problems are found.
+func RunValidate(cmd *cobra.Command, _ []string) {
+	if err := checkReportFormat(); err != nil {
+		log.Fatal(err)
+		return
+	}
+	path := Folder.JoinPath("migrations.xml")
+	issues, err := validateChangelog(path)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	out := cmd.OutOrStdout()
+	rep := newReport("validate")
+	byID := make(map[string][]string)
+	var ids []string
+	for _, i := range issues {
+		if ReportFormat == "" || ReportFile != "" {
+			fmt.Fprintln(out, i)
+		}
+		rep.addResult(reportResult{Rule: "validate", Severity: severityError, Message: i.Message, File: i.File, Line: i.Line},
+			"changelog structure and content checks")
+
+		name := i.ID
+		if name == "" {
+			name = filepath.Base(path)
+		}
+		if _, ok := byID[name]; !ok {
+			ids = append(ids, name)
+		}
+		byID[name] = append(byID[name], i.String())
+	}
+	for _, name := range ids {
+		rep.addCase(reportCase{Name: name, Status: caseFailed, Message: strings.Join(byID[name], "\n")})
+	}
+	if src, _, err := readChangelogSource(path); err == nil && src != nil {
+		for _, m := range src.doc.Items {
+			if _, failed := byID[m.ID]; !failed {
+				rep.addCase(reportCase{Name: m.ID, Status: casePassed})
+			}
+		}
+	}
+	if err := rep.write(out); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if len(issues) > 0 {
+		log.Fatalf("%s: %d problem(s) found", path, len(issues))
+	}
+	if ReportFormat == "" || ReportFile != "" {
+		fmt.Fprintf(out, "%s: OK\n", path)
+	}
+}
@@ -0,0 +1,147 @@
+package baselith
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// runVerify executes the verify SQL of a changeset. The script must succeed and,
+// when it returns a result set, the first column of the first row must be truthy.
+func runVerify(tx *gorm.DB, id, verifySQL string) error {
+	rows, err := tx.Raw(verifySQL).Rows()
+	if err != nil {
+		return fmt.Errorf("%s verify: %w", id, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("%s verify: %w", id, err)
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("%s verify: %w", id, err)
+		}
+		return fmt.Errorf("%s verify: no rows returned", id)
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return fmt.Errorf("%s verify: %w", id, err)
+	}
+	if !truthy(values[0]) {
+		return fmt.Errorf("%s verify: returned %v", id, values[0])
+	}
+	return nil
+}
+
+// truthy reports whether a scanned SQL value counts as true.
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case int64:
+		return t != 0
+	case int32:
+		return t != 0
+	case float64:
+		return t != 0
+	case []byte:
+		return truthyString(string(t))
+	case string:
+		return truthyString(t)
+	default:
+		return true
+	}
+}
+
+func truthyString(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "f", "false", "n", "no":
+		return false
+	}
+	return true
+}
+
+// withVerify wraps up so that the verify SQL runs right after it, on the same
+// connection (and therefore in the same transaction for transactional changesets).
+func withVerify(id string, up func(*gorm.DB) error, verifySQL string) func(*gorm.DB) error {
+	if verifySQL == "" {
+		return up
+	}
+	return func(tx *gorm.DB) error {
+		if err := up(tx); err != nil {
+			return err
+		}
+		return runVerify(tx, id, verifySQL)
+	}
+}
+
+// readVerifySQL reads the optional <includeVerify> file of a changelog.
+func readVerifySQL(m xmlChangelog, baseDir string, props *properties) (string, error) {
+	if m.IncludeVerify == nil {
+		return "", nil
+	}
+	verifySQL, err := readSQL(baseDir, m.IncludeVerify.File, m.IncludeVerify.Rel, props)
+	if err != nil {
+		return "", fmt.Errorf("%s verify: %w", m.ID, err)
+	}
+	return verifySQL, nil
+}
+
+// cmdVerify re-runs the verify scripts of every applied changeset against the current database.
+func cmdVerify(db *gorm.DB, all []*gormigrate.Migration, opts map[string]changesetOptions, reps []*repeatableMigration) error {
+	rows, err := loadMigRows(db)
+	if err != nil {
+		return err
+	}
+	applied := map[string]time.Time{}
+	for _, r := range rows {
+		applied[r.ID] = r.AppliedAt
+	}
+
+	type target struct{ id, sql string }
+	var targets []target
+	for _, gm := range all {
+		targets = append(targets, target{gm.ID, opts[gm.ID].Verify})
+	}
+	for _, r := range reps {
+		targets = append(targets, target{r.ID, r.Options.Verify})
+	}
+
+	log.Println("== Migration Verify ==")
+	failed := 0
+	for _, t := range targets {
+		if _, ok := applied[t.id]; !ok {
+			continue
+		}
+		if t.sql == "" {
+			log.Printf("- %s\t(no verify script)\n", t.id)
+			continue
+		}
+		if err := runVerify(db, t.id, t.sql); err != nil {
+			failed++
+			log.Printf("✗ %s\t%v\n", t.id, err)
+			continue
+		}
+		log.Printf("✓ %s\n", t.id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d verify script(s) failed", failed)
+	}
+	return nil
+}
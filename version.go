@@ -0,0 +1,5 @@
+package baselith
+
+// Version is the baselith release, printed by the version command and recorded
+// with every execution in schema_migrations_log.
+const Version = "1.0.0"
@@ -12,63 +12,113 @@ func loadMigrationsXML(xmlPath string) (*xmlMigrations, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	doc.props = newProperties(doc, Params, configParams, Contexts)
 
 	return doc, baseDir, nil
 }
 
-func readMigrationsXML(doc *xmlMigrations, baseDir string) (tx, noTx []*gormigrate.Migration, metasTx, metasNoTx map[string]Meta, err error) {
+func readMigrationsXML(doc *xmlMigrations, baseDir string) (tx, noTx []*gormigrate.Migration, metasTx, metasNoTx map[string]Meta, opts map[string]changesetOptions, err error) {
 	metasTx = make(map[string]Meta)
 	metasNoTx = make(map[string]Meta)
+	opts = make(map[string]changesetOptions)
 
 	// read and validate each migration
 	for _, m := range doc.Items {
+		if m.ID == "" {
+			return nil, nil, nil, nil, nil, fmt.Errorf("changelog with author %q: missing <id>", m.Author)
+		}
 		if len(m.Author) == 0 {
-			return nil, nil, nil, nil, fmt.Errorf("%s: missing <author>", m.ID)
+			return nil, nil, nil, nil, nil, fmt.Errorf("%s: missing <author>", m.ID)
 		}
 		if len(m.Labels) == 0 {
-			return nil, nil, nil, nil, fmt.Errorf("%s: missing <labels>", m.ID)
+			return nil, nil, nil, nil, nil, fmt.Errorf("%s: missing <labels>", m.ID)
 		}
 		if m.Kind == "" {
-			return nil, nil, nil, nil, fmt.Errorf("%s: missing <kind>", m.ID)
-		}
-		if m.ID == "" {
-			return nil, nil, nil, nil, fmt.Errorf("%s: missing <id>", m.ID)
+			return nil, nil, nil, nil, nil, fmt.Errorf("%s: missing <kind>", m.ID)
 		}
-
 		useTx := true
 		if m.Transactional != nil {
 			useTx = *m.Transactional
 		}
 
+		if m.repeatable() {
+			// repeatable changesets are handled by readRepeatablesXML
+			continue
+		}
+
 		var upFn, downFn func(*gorm.DB) error
 		switch m.Kind {
 		case "sql":
-			if m.IncludeUp == nil {
-				return nil, nil, nil, nil, fmt.Errorf("%s: missing <include> up file", m.ID)
-			}
-			upSQL, err := readSQL(baseDir, m.IncludeUp.File, m.IncludeUp.Rel == "true")
+			upSQL, downSQL, err := readChangelogSQL(m, baseDir, doc.props)
 			if err != nil {
-				return nil, nil, nil, nil, fmt.Errorf("%s up: %w", m.ID, err)
+				return nil, nil, nil, nil, nil, err
 			}
 
-			var downSQL string
+			upStmts, downStmts := sqlStatements(upSQL), sqlStatements(downSQL)
+			upFn = func(tx *gorm.DB) error { return execStatements(tx, upStmts) }
+			downFn = func(tx *gorm.DB) error {
+				if downSQL == "" {
+					return fmt.Errorf("no down SQL for %s", m.ID)
+				}
+				return execStatements(tx, downStmts)
+			}
+
+		case "change":
+			d, err := newDialect(Driver, doc.Schema)
+			if err != nil {
+				return nil, nil, nil, nil, nil, fmt.Errorf("%s: %w", m.ID, err)
+			}
+			upStmts, downStmts, noDown, err := buildChanges(d, m)
+			if err != nil {
+				return nil, nil, nil, nil, nil, err
+			}
+			// an explicit <includeDown> takes precedence over the generated inverse
 			if m.IncludeDown != nil {
-				downSQL, err = readSQL(baseDir, m.IncludeDown.File, m.IncludeDown.Rel == "true")
+				downSQL, err := readSQL(baseDir, m.IncludeDown.File, m.IncludeDown.Rel, doc.props)
 				if err != nil {
-					return nil, nil, nil, nil, fmt.Errorf("%s down: %w", m.ID, err)
+					return nil, nil, nil, nil, nil, fmt.Errorf("%s down: %w", m.ID, err)
 				}
+				downStmts, noDown = []string{downSQL}, ""
 			}
 
-			upFn = func(tx *gorm.DB) error { return tx.Exec(upSQL).Error }
+			upFn = func(tx *gorm.DB) error { return execStatements(tx, upStmts) }
 			downFn = func(tx *gorm.DB) error {
-				if downSQL == "" {
-					return fmt.Errorf("no down SQL for %s", m.ID)
+				if noDown != "" {
+					return fmt.Errorf("no automatic rollback for <%s> in %s; add an <includeDown>", noDown, m.ID)
+				}
+				return execStatements(tx, downStmts)
+			}
+
+		case "loadData":
+			d, err := newDialect(Driver, doc.Schema)
+			if err != nil {
+				return nil, nil, nil, nil, nil, fmt.Errorf("%s: %w", m.ID, err)
+			}
+			ld, err := newLoadData(d, m, baseDir)
+			if err != nil {
+				return nil, nil, nil, nil, nil, err
+			}
+
+			upFn = ld.load
+			downFn = func(tx *gorm.DB) error {
+				if err := ld.unload(tx); err != nil {
+					return fmt.Errorf("%s: %w", m.ID, err)
 				}
-				return tx.Exec(downSQL).Error
+				return nil
 			}
 
 		default:
-			return nil, nil, nil, nil, fmt.Errorf("%s: unsupported type=%s", m.ID, m.Kind)
+			return nil, nil, nil, nil, nil, fmt.Errorf("%s: unsupported type=%s", m.ID, m.Kind)
+		}
+
+		verifySQL, err := readVerifySQL(m, baseDir, doc.props)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+
+		timeout, lockTimeout, err := changesetTimeouts(m)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
 		}
 
 		meta := Meta{
@@ -77,11 +127,17 @@ func readMigrationsXML(doc *xmlMigrations, baseDir string) (tx, noTx []*gormigra
 			Kind:          m.Kind,
 			Transactional: useTx,
 		}
+		opts[m.ID] = changesetOptions{
+			Verify:      verifySQL,
+			Timeout:     timeout,
+			LockTimeout: lockTimeout,
+			Retryable:   m.Retryable,
+		}
 
 		gm := &gormigrate.Migration{
 			ID:       m.ID,
-			Migrate:  upFn,
-			Rollback: downFn,
+			Migrate:  withTimeouts(withVerify(m.ID, upFn, verifySQL), timeout, lockTimeout),
+			Rollback: withTimeouts(downFn, timeout, lockTimeout),
 		}
 
 		if useTx {
@@ -92,5 +148,65 @@ func readMigrationsXML(doc *xmlMigrations, baseDir string) (tx, noTx []*gormigra
 			metasNoTx[m.ID] = meta
 		}
 	}
-	return tx, noTx, metasTx, metasNoTx, nil
+	return tx, noTx, metasTx, metasNoTx, opts, nil
+}
+
+// readChangelogSQL reads the up and (optional) down SQL files of a "sql" changelog.
+func readChangelogSQL(m xmlChangelog, baseDir string, props *properties) (upSQL, downSQL string, err error) {
+	if m.IncludeUp == nil {
+		return "", "", fmt.Errorf("%s: missing <include> up file", m.ID)
+	}
+	upSQL, err = readSQL(baseDir, m.IncludeUp.File, m.IncludeUp.Rel, props)
+	if err != nil {
+		return "", "", fmt.Errorf("%s up: %w", m.ID, err)
+	}
+
+	if m.IncludeDown != nil {
+		downSQL, err = readSQL(baseDir, m.IncludeDown.File, m.IncludeDown.Rel, props)
+		if err != nil {
+			return "", "", fmt.Errorf("%s down: %w", m.ID, err)
+		}
+	}
+	return upSQL, downSQL, nil
+}
+
+// statementError is the failure of the statement at Index (1-based) of a changeset.
+type statementError struct {
+	Index int
+	Err   error
+}
+
+func (e *statementError) Error() string {
+	return fmt.Sprintf("statement %d: %v", e.Index, e.Err)
+}
+
+func (e *statementError) Unwrap() error {
+	return e.Err
+}
+
+// sqlStatements splits the SQL of a file into the statements executed one by
+// one, leaving out those that are only comments. When the split may not match
+// the server's, because of backslashes in strings, the file is executed whole.
+func sqlStatements(sql string) []string {
+	split, exact := scanStatements(sql)
+	if !exact {
+		return []string{sql}
+	}
+	var stmts []string
+	for _, s := range split {
+		if leadingTrivia(s.SQL) < len(s.SQL) {
+			stmts = append(stmts, s.SQL)
+		}
+	}
+	return stmts
+}
+
+// execStatements executes each statement in order, stopping at the first error.
+func execStatements(tx *gorm.DB, stmts []string) error {
+	for i, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return &statementError{Index: i + 1, Err: err}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,36 @@
+package baselith
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// ChangelogVersion is the changelog format version written by baselith and
+// described by the embedded XSD.
+const ChangelogVersion = "1"
+
+//go:embed xsd/baselith-changelog-1.xsd
+var changelogXSD string
+
+// supportedVersions lists the changelog format versions parseXML understands.
+var supportedVersions = map[string]bool{
+	"1": true,
+}
+
+// checkVersion validates the version attribute of <migrations>; an absent version means version 1.
+func checkVersion(doc *xmlMigrations) error {
+	if doc.Version == "" {
+		doc.Version = ChangelogVersion
+	}
+	if !supportedVersions[doc.Version] {
+		return fmt.Errorf("unsupported changelog version %q (supported: %s)", doc.Version, ChangelogVersion)
+	}
+	return nil
+}
+
+// RunSchemaXSD prints the XSD of the current changelog format.
+func RunSchemaXSD(cmd *cobra.Command, _ []string) {
+	fmt.Fprint(cmd.OutOrStdout(), changelogXSD)
+}
@@ -1,14 +1,24 @@
 package baselith
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/hinha/baselith/persistence"
 	"gopkg.in/yaml.v3"
 )
 
-// DBConfigYAML represents the expected structure of the YAML config file.
+// DBConfigYAML represents the expected structure of the YAML config file. Every
+// key can also be set with a flag or a BASELITH_<KEY> environment variable.
 type DBConfigYAML struct {
+	// URL holds the connection settings below in one connection URL
+	URL string `yaml:"url"`
+
 	Driver   string `yaml:"driver"`
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
@@ -16,24 +26,209 @@ type DBConfigYAML struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Schema   string `yaml:"schema"`
+
+	// Password sources, instead of password
+	PasswordFile    string `yaml:"password_file"`    // file holding the password
+	PasswordEnv     string `yaml:"password_env"`     // environment variable holding the password
+	PasswordCommand string `yaml:"password_command"` // command printing the password
+
+	// Connection settings of persistence.DBConfig
+	SSLMode         string            `yaml:"sslmode"`
+	Options         map[string]string `yaml:"options"` // driver connection parameters
+	MaxIdleConns    int               `yaml:"max_idle_conns"`
+	MaxOpenConns    int               `yaml:"max_open_conns"`
+	ConnMaxLifetime time.Duration     `yaml:"conn_max_lifetime"`
+
+	// Changelog selection
+	Folder   string `yaml:"folder"`
+	Contexts string `yaml:"contexts"`
+	Labels   string `yaml:"labels"`
+
+	// Locking and timeouts
+	LockMode             string        `yaml:"lock_mode"`
+	LockTimeout          time.Duration `yaml:"lock_timeout"`
+	StatementTimeout     time.Duration `yaml:"statement_timeout"`
+	StatementLockTimeout time.Duration `yaml:"statement_lock_timeout"`
+	WaitTimeout          time.Duration `yaml:"wait_timeout"`
+
+	// Params are used for ${name} substitution in SQL files
+	Params map[string]string `yaml:"params"`
 }
 
+// configParams holds the params of the YAML config file once it has been read.
+var configParams map[string]string
+
 // ReadConfigYAML reads and parses the YAML config file if ConfigYaml is true and ConfigPath is set.
 // Returns DBConfigYAML and error.
 func ReadConfigYAML() (*DBConfigYAML, error) {
 	if !ConfigYaml || ConfigPath == "" {
 		return nil, fmt.Errorf("YAML config not enabled or path not set")
 	}
-	file, err := os.Open(ConfigPath)
+	return readConfigYAMLFile(ConfigPath)
+}
+
+// applyURL fills the connection settings of c that are not set from its URL.
+func (c *DBConfigYAML) applyURL() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := persistence.ParseURL(c.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	set := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	set(&c.Driver, u.Driver)
+	set(&c.Host, u.Host)
+	set(&c.Dbname, u.Database)
+	set(&c.User, u.Username)
+	set(&c.Password, u.Password)
+	set(&c.SSLMode, u.SSLMode)
+	if c.Port == 0 {
+		c.Port = u.Port
+	}
+	if c.Options == nil {
+		c.Options = u.Params
+	}
+	return nil
+}
+
+// configFileYAML is the config file: shared settings at the top level and
+// named environments overriding them.
+type configFileYAML struct {
+	DBConfigYAML `yaml:",inline"`
+	Environments map[string]DBConfigYAML `yaml:"environments"`
+}
+
+// configFile holds the settings of a config file as written, for merging.
+type configFile struct {
+	shared       map[string]any
+	environments map[string]map[string]any
+}
+
+// names returns the environment names, sorted.
+func (f *configFile) names() []string {
+	names := make([]string, 0, len(f.environments))
+	for name := range f.environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// settings returns the shared settings with those of environment env merged
+// over them, or just the shared settings when env is "".
+func (f *configFile) settings(path, env string) (map[string]any, error) {
+	if env == "" {
+		return f.shared, nil
+	}
+	over, ok := f.environments[env]
+	if !ok {
+		if len(f.environments) == 0 {
+			return nil, fmt.Errorf("environment %q: %s has no environments", env, path)
+		}
+		return nil, fmt.Errorf("environment %q not found in %s (have: %s)", env, path, strings.Join(f.names(), ", "))
+	}
+	merged := make(map[string]any, len(f.shared)+len(over))
+	for k, v := range f.shared {
+		merged[k] = v
+	}
+	for k, v := range over {
+		// params and options are merged key by key
+		if base, ok := merged[k].(map[string]any); ok {
+			if m, ok := v.(map[string]any); ok {
+				both := make(map[string]any, len(base)+len(m))
+				for mk, mv := range base {
+					both[mk] = mv
+				}
+				for mk, mv := range m {
+					both[mk] = mv
+				}
+				v = both
+			}
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// readConfigFile parses the config file at path. Unknown keys are errors, in
+// the shared settings as well as in every environment.
+func readConfigFile(path string) (*configFile, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open YAML file: %w", err)
 	}
-	defer file.Close()
 
+	var strict configFileYAML
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&strict); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse YAML %s: %w", path, err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML %s: %w", path, err)
+	}
+	f := &configFile{shared: raw, environments: map[string]map[string]any{}}
+	if envs, ok := raw["environments"].(map[string]any); ok {
+		for name, v := range envs {
+			m, _ := v.(map[string]any)
+			f.environments[name] = m
+		}
+	}
+	delete(raw, "environments")
+	return f, nil
+}
+
+// readConfigYAMLFile parses the shared settings of the YAML config file at path.
+func readConfigYAMLFile(path string) (*DBConfigYAML, error) {
+	cfg, _, err := readConfigYAMLKeys(path, "")
+	return cfg, err
+}
+
+// readConfigYAMLKeys parses the YAML config file at path, with the settings of
+// environment env merged over the shared ones, and also returns the value of
+// every key set, as text.
+func readConfigYAMLKeys(path, env string) (*DBConfigYAML, map[string]string, error) {
+	f, err := readConfigFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings, err := f.settings(path, env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the file has been checked already, re-decode the merged settings
+	b, err := yaml.Marshal(settings)
+	if err != nil {
+		return nil, nil, err
+	}
 	var cfg DBConfigYAML
-	decoder := yaml.NewDecoder(file)
-	if err := decoder.Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse YAML %s: %w", path, err)
+	}
+
+	keys := make(map[string]string, len(settings))
+	for k, v := range settings {
+		switch v := v.(type) {
+		case nil:
+		case map[string]any:
+			// maps are taken from the decoded config, this text is for messages
+			pairs := make([]string, 0, len(v))
+			for pk, pv := range v {
+				pairs = append(pairs, pk+"="+fmt.Sprint(pv))
+			}
+			sort.Strings(pairs)
+			keys[k] = strings.Join(pairs, ",")
+		default:
+			keys[k] = fmt.Sprint(v)
+		}
 	}
-	return &cfg, nil
+	return &cfg, keys, nil
 }